@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/skaronator/lancache-dns-sync/internal/config"
+	"github.com/skaronator/lancache-dns-sync/internal/service"
+)
+
+const commandsUsage = `
+Commands:
+  cleanup [-dry-run] [-drop-corrupted-markers]
+                     Remove all rules managed by lancache-dns-sync from AdGuard
+  restore [backup]   List user rules backups, or restore the named backup
+  pause -for 2h      Remove the managed rules until the pause expires
+  resume             End the pause, the daemon restores the managed rules
+`
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [command]\n\nFlags:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprint(flag.CommandLine.Output(), commandsUsage)
+}
+
+// runCommand runs a maintenance command instead of the sync and returns the exit code.
+func runCommand(ctx context.Context, cfg *config.Config, syncService *service.SyncService, name string, args []string) int {
+	switch name {
+	case "cleanup":
+		return runCleanup(ctx, syncService, args)
+	case "restore":
+		return runRestore(ctx, syncService, args)
+	case "pause":
+		return runPause(ctx, cfg, syncService, args)
+	case "resume":
+		return runResume(cfg, syncService, args)
+	default:
+		slog.Error("Unknown command", "command", name)
+		usage()
+		return exitGeneric
+	}
+}
+
+func runCleanup(ctx context.Context, syncService *service.SyncService, args []string) int {
+	flags := flag.NewFlagSet("cleanup", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "Show what would be removed without changing AdGuard")
+	dropMarkers := flags.Bool("drop-corrupted-markers", false, "Remove only the markers if they are corrupted, keeping the rules between them")
+	if err := flags.Parse(args); err != nil {
+		return exitGeneric
+	}
+
+	result, err := syncService.Cleanup(ctx, service.CleanupOptions{DryRun: *dryRun, DropCorruptedMarkers: *dropMarkers})
+	if err != nil {
+		slog.Error("Cleanup failed", "error", err)
+		return exitCode(err)
+	}
+
+	verb := "Removed"
+	if result.DryRun {
+		verb = "Would remove"
+	}
+
+	if len(result.RemovedRules) == 0 && result.FilterListURL == "" {
+		fmt.Println("Nothing to clean up")
+		return 0
+	}
+	if len(result.RemovedRules) > 0 {
+		fmt.Printf("%s %d rules from the user rules\n", verb, len(result.RemovedRules))
+		if result.DryRun {
+			for _, rule := range result.RemovedRules {
+				fmt.Println("  - " + rule)
+			}
+		}
+	}
+	if result.FilterListURL != "" {
+		fmt.Printf("%s filter list %s\n", verb, result.FilterListURL)
+	}
+	return 0
+}
+
+func runRestore(ctx context.Context, syncService *service.SyncService, args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return exitGeneric
+	}
+
+	if flags.NArg() == 0 {
+		names, err := syncService.ListBackups()
+		if err != nil {
+			slog.Error("Failed to list backups", "error", err)
+			return exitGeneric
+		}
+		if len(names) == 0 {
+			fmt.Println("No backups found")
+			return 0
+		}
+		fmt.Println("Available backups (newest first):")
+		for _, name := range names {
+			fmt.Println("  " + name)
+		}
+		return 0
+	}
+
+	if err := syncService.RestoreBackup(ctx, flags.Arg(0)); err != nil {
+		slog.Error("Restore failed", "error", err)
+		return exitCode(err)
+	}
+	return 0
+}
+
+// errPauseNeedsState is reported by the pause commands, which only reach a
+// running daemon through the state directory.
+var errPauseNeedsState = errors.New("pausing needs STATE_DIR, shared with the daemon, to remember the pause")
+
+func runPause(ctx context.Context, cfg *config.Config, syncService *service.SyncService, args []string) int {
+	flags := flag.NewFlagSet("pause", flag.ContinueOnError)
+	duration := flags.Duration("for", 0, "How long to pause, e.g. 2h")
+	if err := flags.Parse(args); err != nil {
+		return exitGeneric
+	}
+	if *duration <= 0 {
+		slog.Error("Pause failed", "error", "-for must be a positive duration like 2h")
+		return exitGeneric
+	}
+	if cfg.StateDir == "" {
+		slog.Error("Pause failed", "error", errPauseNeedsState)
+		return exitGeneric
+	}
+
+	until, err := syncService.Pause(ctx, *duration)
+	if err != nil {
+		slog.Error("Pause failed", "error", err)
+		return exitCode(err)
+	}
+	fmt.Printf("Paused until %s, the daemon restores the managed rules then\n", until.Local().Format(time.RFC3339))
+	return 0
+}
+
+func runResume(cfg *config.Config, syncService *service.SyncService, args []string) int {
+	flags := flag.NewFlagSet("resume", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return exitGeneric
+	}
+	if cfg.StateDir == "" {
+		slog.Error("Resume failed", "error", errPauseNeedsState)
+		return exitGeneric
+	}
+
+	resumed, err := syncService.Resume()
+	if err != nil {
+		slog.Error("Resume failed", "error", err)
+		return exitGeneric
+	}
+	if !resumed {
+		fmt.Println("Not paused")
+		return 0
+	}
+	fmt.Println("Resumed, the daemon restores the managed rules within a minute")
+	return 0
+}
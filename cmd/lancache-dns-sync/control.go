@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/skaronator/lancache-dns-sync/internal/scheduler"
+	"github.com/skaronator/lancache-dns-sync/internal/service"
+)
+
+// pauseCheckInterval is how often the daemon looks for pauses set or ended by
+// the pause and resume commands.
+const pauseCheckInterval = time.Minute
+
+// watchPause runs a sync when the pause changed, so the daemon keeps the
+// managed rules removed and restores them when the pause expires.
+func watchPause(ctx context.Context, syncService *service.SyncService, runner *scheduler.Runner) {
+	ticker := time.NewTicker(pauseCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if syncService.PauseChanged() {
+			slog.Info("Pause changed, running a sync")
+			runner.Trigger()
+		}
+	}
+}
+
+// handleControl mounts the pause and resume endpoints on mux. Requests must
+// send token as bearer token.
+func handleControl(mux *http.ServeMux, token string, syncService *service.SyncService, runner *scheduler.Runner) {
+	mux.Handle("POST /pause", requireToken(token, pauseHandler(syncService)))
+	mux.Handle("POST /resume", requireToken(token, resumeHandler(syncService, runner)))
+}
+
+// requireToken rejects requests without the bearer token.
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or invalid bearer token, see CONTROL_TOKEN"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// pauseHandler serves POST /pause?for=2h, the HTTP version of the pause command.
+func pauseHandler(syncService *service.SyncService) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, err := time.ParseDuration(r.URL.Query().Get("for"))
+		if err != nil || d <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "the for parameter must be a positive duration like 2h"})
+			return
+		}
+
+		until, err := syncService.Pause(r.Context(), d)
+		if err != nil {
+			slog.Error("Pause failed", "error", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"paused_until": until})
+	})
+}
+
+// resumeHandler serves POST /resume, which ends the pause and restores the
+// managed rules right away.
+func resumeHandler(syncService *service.SyncService, runner *scheduler.Runner) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resumed, err := syncService.Resume()
+		if err != nil {
+			slog.Error("Resume failed", "error", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		if resumed {
+			runner.Trigger()
+		}
+		writeJSON(w, http.StatusOK, map[string]bool{"resumed": resumed})
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Failed to write response", "error", err)
+	}
+}
@@ -2,18 +2,26 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
+	// Embeds the time zone database for SYNC_TIMEZONE, the image has none
+	_ "time/tzdata"
 
 	"github.com/skaronator/lancache-dns-sync/internal/client"
 	"github.com/skaronator/lancache-dns-sync/internal/config"
 	"github.com/skaronator/lancache-dns-sync/internal/domain"
+	"github.com/skaronator/lancache-dns-sync/internal/filterlist"
+	"github.com/skaronator/lancache-dns-sync/internal/scheduler"
 	"github.com/skaronator/lancache-dns-sync/internal/service"
 )
 
@@ -23,12 +31,54 @@ var (
 	date    = "unknown"
 )
 
+// Exit codes for failed syncs, so scripts running with -once can tell
+// AdGuard API failures apart.
+const (
+	exitGeneric      = 1
+	exitUnauthorized = 3
+	exitNotFound     = 4
+	exitValidation   = 5
+	exitServer       = 6
+	exitNetwork      = 7
+	exitMassRemoval  = 8
+	exitMarkers      = 9
+	exitCanceled     = 10
+)
+
+func exitCode(err error) int {
+	switch {
+	// Canceled requests are network errors, too
+	case errors.Is(err, context.Canceled):
+		return exitCanceled
+	case errors.Is(err, client.ErrUnauthorized):
+		return exitUnauthorized
+	case errors.Is(err, client.ErrNotFound):
+		return exitNotFound
+	case errors.Is(err, client.ErrValidation):
+		return exitValidation
+	case errors.Is(err, client.ErrServer):
+		return exitServer
+	case errors.Is(err, client.ErrNetwork):
+		return exitNetwork
+	case errors.Is(err, service.ErrMassRemoval):
+		return exitMassRemoval
+	case errors.Is(err, service.ErrCorruptedMarkers):
+		return exitMarkers
+	default:
+		return exitGeneric
+	}
+}
+
 func main() {
 	var (
 		showVersion = flag.Bool("version", false, "Show version information")
 		runOnce     = flag.Bool("once", false, "Run once and exit")
 		daemon      = flag.Bool("daemon", true, "Run as daemon with scheduling")
+		dryRun      = flag.Bool("dry-run", false, "Print the changes a sync would make without writing them, then exit")
+		allowRemove = flag.Bool("allow-mass-removal", false, "Apply changes even if they remove more managed rules than the configured limits")
+		jsonOutput  = flag.Bool("json", false, "With -once, print the sync result as JSON to stdout and log to stderr")
 	)
+	flag.Usage = usage
 	flag.Parse()
 
 	if *showVersion {
@@ -40,7 +90,12 @@ func main() {
 	opts := &slog.HandlerOptions{
 		Level: slog.LevelDebug,
 	}
-	handler := slog.NewTextHandler(os.Stdout, opts)
+	logOutput := os.Stdout
+	if *jsonOutput {
+		// Keep stdout clean for the result
+		logOutput = os.Stderr
+	}
+	handler := slog.NewTextHandler(logOutput, opts)
 	logger := slog.New(handler)
 	slog.SetDefault(logger)
 
@@ -58,48 +113,188 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *allowRemove {
+		cfg.RemovalLimits.Override = true
+	}
+
+	if *dryRun {
+		if cfg.SyncMode != config.SyncModeUserRules {
+			slog.Error("Configuration error", "error", "dry run is only supported in user_rules mode")
+			os.Exit(1)
+		}
+		cfg.DryRun = true
+		*runOnce = true
+	}
+
 	// Create HTTP client with timeout
 	httpClient := &http.Client{
 		Timeout: cfg.Timeout,
 	}
 
 	// Create services
-	adguardClient := client.NewAdguardClient(cfg.AdguardAPI.String(), cfg.Username, cfg.Password, cfg.Timeout)
+	var clientOpts []client.Option
+	if cfg.AuthMode == config.AuthModeSession {
+		clientOpts = append(clientOpts, client.WithSessionAuth())
+	}
+	tlsOptions := client.TLSOptions(cfg.AdguardTLS)
+	if !tlsOptions.IsZero() {
+		tlsConfig, err := client.LoadTLSConfig(tlsOptions)
+		if err != nil {
+			slog.Error("Configuration error", "error", err)
+			os.Exit(1)
+		}
+		clientOpts = append(clientOpts, client.WithTLSConfig(tlsConfig))
+	}
+	syncMetrics := newSyncMetrics()
+	clientOpts = append(clientOpts, client.WithRequestObserver(syncMetrics.observeRequest))
+
+	adguardClient := client.NewAdguardClient(cfg.AdguardAPI.String(), cfg.Username, cfg.Password, cfg.Timeout, clientOpts...)
 	downloader := domain.NewDownloader(httpClient)
 	syncService := service.NewSyncService(adguardClient, downloader, cfg)
+	syncService.SetVersion(version)
+	if *jsonOutput {
+		syncService.SetOutput(os.Stderr)
+	}
 
-	ctx := context.Background()
+	// SIGINT and SIGTERM cancel a running sync, a write to AdGuard that already
+	// started is finished first
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	// Run sync once
-	if err := syncService.SyncDomains(ctx); err != nil {
-		slog.Error("Sync failed", "error", err)
-		os.Exit(1)
+	// Run a maintenance command instead of syncing
+	if command := flag.Arg(0); command != "" {
+		os.Exit(runCommand(ctx, cfg, syncService, command, flag.Args()[1:]))
 	}
 
-	// If running once, exit after first sync
+	var runner *scheduler.Runner
+	if !*runOnce && *daemon {
+		runner = scheduler.NewRunner(cfg.Schedule, func(ctx context.Context) error {
+			result, err := syncService.SyncDomains(ctx)
+			syncMetrics.observeSync(result, err)
+			if result.PausedUntil != nil {
+				// Restore the rules as soon as the pause expires
+				runner.WakeAt(*result.PausedUntil)
+			}
+			return err
+		}, scheduler.WithJitter(cfg.SyncJitter), scheduler.WithBackoff(cfg.RetryBackoff))
+	}
+
+	// The filter list and the metrics share a server if they listen on the
+	// same address
+	muxes := make(map[string]*http.ServeMux)
+	handle := func(listen, pattern string, handler http.Handler) {
+		if muxes[listen] == nil {
+			muxes[listen] = http.NewServeMux()
+		}
+		muxes[listen].Handle(pattern, handler)
+	}
+	if cfg.SyncMode == config.SyncModeFilterList {
+		if *runOnce || !*daemon {
+			slog.Error("Configuration error", "error", "filter_list mode requires daemon mode to serve the filter list")
+			os.Exit(1)
+		}
+		handle(cfg.FilterList.Listen, filterlist.Path, syncService.FilterList())
+	}
+	if cfg.MetricsListen != "" && runner != nil {
+		handle(cfg.MetricsListen, "GET /metrics", syncMetrics.registry)
+	}
+	if cfg.ControlToken != "" {
+		if len(muxes) == 0 {
+			slog.Warn("CONTROL_TOKEN is set, but neither the filter list nor the metrics are served")
+		}
+		for _, mux := range muxes {
+			handleControl(mux, cfg.ControlToken, syncService, runner)
+		}
+	}
+	for listen, mux := range muxes {
+		server := &http.Server{
+			Addr:              listen,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			slog.Info("Serving HTTP", "listen", listen)
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("HTTP server failed", "listen", listen, "error", err)
+				os.Exit(1)
+			}
+		}()
+		defer func() {
+			if err := server.Close(); err != nil {
+				slog.Error("Failed to close HTTP server", "listen", listen, "error", err)
+			}
+		}()
+	}
+
+	// Without the daemon, sync once and report failures in the exit code
 	if *runOnce || !*daemon {
+		result, err := syncService.SyncDomains(ctx)
+		syncMetrics.observeSync(result, err)
+		if *jsonOutput {
+			if err := writeResult(os.Stdout, result); err != nil {
+				slog.Error("Failed to write sync result", "error", err)
+			}
+		}
+		if err != nil {
+			slog.Error("Sync failed", "error", err)
+			os.Exit(exitCode(err))
+		}
 		return
 	}
 
-	slog.Info("Running in daemon mode", "interval", cfg.SyncInterval)
-
-	// Set up signal handling for graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	slog.Info("Running in daemon mode", "schedule", cfg.Schedule)
 
-	ticker := time.NewTicker(cfg.SyncInterval)
-	defer ticker.Stop()
+	// The first sync runs right away and is retried with the backoff if it
+	// fails, e.g. while AdGuard Home is still starting
+	runner.Trigger()
+	go watchPause(ctx, syncService, runner)
 
-	for {
-		select {
-		case <-ticker.C:
-			slog.Info("Running scheduled sync")
-			if err := syncService.SyncDomains(ctx); err != nil {
-				slog.Error("Scheduled sync failed", "error", err)
+	// Reload the configuration on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		current := cfg
+		for range hup {
+			next, err := reloadConfig(current, *allowRemove)
+			if err != nil {
+				slog.Error("Failed to reload configuration, keeping the current one", "error", err)
+				continue
 			}
-		case sig := <-sigChan:
-			slog.Info("Received signal, shutting down gracefully", "signal", sig)
-			return
+			current = next
+			syncService.Reload(next)
+			runner.Reconfigure(next.Schedule, scheduler.WithJitter(next.SyncJitter), scheduler.WithBackoff(next.RetryBackoff))
+			slog.Info("Configuration reloaded", "schedule", next.Schedule)
+			runner.Trigger()
 		}
+	}()
+
+	if err := runner.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		slog.Error("Scheduler failed", "error", err)
+		os.Exit(exitGeneric)
 	}
+	slog.Info("Received signal, shutting down gracefully")
+}
+
+// reloadConfig loads the configuration again for a running daemon. Settings
+// that only take effect on restart must be unchanged.
+func reloadConfig(current *config.Config, allowRemove bool) (*config.Config, error) {
+	next, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	if changed := current.RestartRequired(next); len(changed) > 0 {
+		return nil, fmt.Errorf("changing %s requires a restart", strings.Join(changed, ", "))
+	}
+	if allowRemove {
+		next.RemovalLimits.Override = true
+	}
+	return next, nil
+}
+
+// writeResult prints the sync result as indented JSON.
+func writeResult(w io.Writer, result service.SyncResult) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
 }
@@ -1,8 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/skaronator/lancache-dns-sync/internal/client"
+	"github.com/skaronator/lancache-dns-sync/internal/config"
+	"github.com/skaronator/lancache-dns-sync/internal/scheduler"
+	"github.com/skaronator/lancache-dns-sync/internal/service"
+	"github.com/skaronator/lancache-dns-sync/internal/types"
 )
 
 func TestRunOnceEnvironmentVariable(t *testing.T) {
@@ -50,3 +65,160 @@ func TestRunOnceEnvironmentVariable(t *testing.T) {
 		})
 	}
 }
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"generic", errors.New("failed"), exitGeneric},
+		{"unauthorized", &client.APIError{Kind: client.ErrUnauthorized}, exitUnauthorized},
+		{"not found", &client.APIError{Kind: client.ErrNotFound}, exitNotFound},
+		{"validation", &client.APIError{Kind: client.ErrValidation}, exitValidation},
+		{"server", &client.APIError{Kind: client.ErrServer}, exitServer},
+		{"mass removal", fmt.Errorf("failed to update filtering rules: %w", service.ErrMassRemoval), exitMassRemoval},
+		{"corrupted markers", fmt.Errorf("failed to update filtering rules: %w", service.ErrCorruptedMarkers), exitMarkers},
+		{"wrapped network", fmt.Errorf("failed to update filtering rules: %w", &client.APIError{Kind: client.ErrNetwork}), exitNetwork},
+		{"canceled", fmt.Errorf("failed to download domain files: %w", context.Canceled), exitCanceled},
+		{"canceled request", &client.APIError{Kind: client.ErrNetwork, Err: context.Canceled}, exitCanceled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.expected {
+				t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestWriteResult(t *testing.T) {
+	result := service.SyncResult{
+		AdguardWritten: true,
+		Services:       []service.ServiceResult{{Name: "steam", Domains: 2, Added: 1}},
+		DomainsAdded:   []string{"steamcontent.com"},
+		Targets:        []service.TargetResult{{Target: "user_rules", Rules: 2, Written: true}},
+	}
+
+	var buf bytes.Buffer
+	if err := writeResult(&buf, result); err != nil {
+		t.Fatalf("writeResult() error = %v", err)
+	}
+
+	var decoded map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("Expected valid JSON, got %q: %v", buf.String(), err)
+	}
+	if decoded["adguard_written"] != true {
+		t.Errorf("Expected adguard_written true, got %v", decoded["adguard_written"])
+	}
+	if added, ok := decoded["domains_added"].([]any); !ok || len(added) != 1 || added[0] != "steamcontent.com" {
+		t.Errorf("Expected domains_added [steamcontent.com], got %v", decoded["domains_added"])
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	t.Setenv("ADGUARD_USERNAME", "admin")
+	t.Setenv("ADGUARD_PASSWORD", "password")
+	t.Setenv("LANCACHE_SERVER", "192.168.1.100")
+	t.Setenv("ADGUARD_API", "http://localhost:3000")
+	t.Setenv("SERVICE_NAMES", "steam")
+
+	current, err := config.Load()
+	if err != nil {
+		t.Fatalf("config.Load() error = %v", err)
+	}
+
+	t.Setenv("SERVICE_NAMES", "steam,wsus")
+	next, err := reloadConfig(current, true)
+	if err != nil {
+		t.Fatalf("reloadConfig() error = %v", err)
+	}
+	if len(next.ServiceNames) != 2 || !next.RemovalLimits.Override {
+		t.Errorf("Expected the new services and the -allow-mass-removal override, got %+v", next)
+	}
+
+	t.Setenv("SERVICE_NAMES", "")
+	if _, err := reloadConfig(current, false); err == nil {
+		t.Error("Expected error for an invalid configuration")
+	}
+
+	t.Setenv("SERVICE_NAMES", "steam")
+	for _, env := range []struct{ name, value string }{
+		{"ADGUARD_API", "http://adguard.lan:3000"},
+		{"TIMEOUT", "1m"},
+		{"INSTANCE_ID", "lanparty"},
+	} {
+		t.Run(env.name, func(t *testing.T) {
+			t.Setenv(env.name, env.value)
+			_, err := reloadConfig(current, false)
+			if err == nil || !strings.Contains(err.Error(), env.name) {
+				t.Errorf("Expected %s to require a restart, got %v", env.name, err)
+			}
+		})
+	}
+}
+
+// fakeAdguard is an AdGuard without user rules.
+type fakeAdguard struct{}
+
+func (fakeAdguard) GetFilteringStatus(context.Context) (*types.FilterStatus, error) {
+	return &types.FilterStatus{}, nil
+}
+func (fakeAdguard) SetFilteringRules(context.Context, []string) error  { return nil }
+func (fakeAdguard) AddFilterURL(context.Context, string, string) error { return nil }
+func (fakeAdguard) RemoveFilterURL(context.Context, string) error      { return nil }
+func (fakeAdguard) RefreshFilters(context.Context) error               { return nil }
+
+func TestPauseHandlers(t *testing.T) {
+	syncService := service.NewSyncService(fakeAdguard{}, nil, &config.Config{SyncMode: config.SyncModeUserRules})
+	runner := scheduler.NewRunner(scheduler.Interval(time.Hour), nil)
+
+	mux := http.NewServeMux()
+	handleControl(mux, "s3cret", syncService, runner)
+
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		token          string
+		expectedStatus int
+		expectedKey    string
+	}{
+		{"missing token", http.MethodPost, "/pause?for=2h", "", http.StatusUnauthorized, "error"},
+		{"wrong token", http.MethodPost, "/resume", "guess", http.StatusUnauthorized, "error"},
+		{"invalid duration", http.MethodPost, "/pause?for=soon", "s3cret", http.StatusBadRequest, "error"},
+		{"pause", http.MethodPost, "/pause?for=2h", "s3cret", http.StatusOK, "paused_until"},
+		{"resume", http.MethodPost, "/resume", "s3cret", http.StatusOK, "resumed"},
+		{"wrong method", http.MethodGet, "/pause?for=2h", "s3cret", http.StatusMethodNotAllowed, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedStatus, rec.Code, rec.Body)
+			}
+			if tt.expectedKey == "" {
+				return
+			}
+			var body map[string]any
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Invalid JSON response: %v", err)
+			}
+			if _, ok := body[tt.expectedKey]; !ok {
+				t.Errorf("Expected %q in response, got %v", tt.expectedKey, body)
+			}
+		})
+	}
+
+	if syncService.PauseChanged() {
+		t.Error("Expected the resumed pause to match the last sync")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/skaronator/lancache-dns-sync/internal/metrics"
+	"github.com/skaronator/lancache-dns-sync/internal/service"
+)
+
+// requestBuckets are the upper bounds of the AdGuard request durations in seconds.
+var requestBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// syncMetrics are the metrics served on METRICS_LISTEN.
+type syncMetrics struct {
+	registry         *metrics.Registry
+	phaseDuration    *metrics.Gauge
+	lastSuccess      *metrics.Gauge
+	failures         *metrics.Counter
+	healthy          *metrics.Gauge
+	domains          *metrics.Gauge
+	managedRules     *metrics.Gauge
+	downloadFailures *metrics.Counter
+	requestDuration  *metrics.Histogram
+	requests         *metrics.Counter
+	adguardUp        *metrics.Gauge
+}
+
+func newSyncMetrics() *syncMetrics {
+	r := metrics.NewRegistry()
+	return &syncMetrics{
+		registry:         r,
+		phaseDuration:    r.Gauge("lancache_dns_sync_phase_duration_seconds", "Duration of the phases of the last sync.", "phase"),
+		lastSuccess:      r.Gauge("lancache_dns_sync_last_success_timestamp_seconds", "Unix time of the last successful sync."),
+		failures:         r.Counter("lancache_dns_sync_failures_total", "Failed syncs by reason.", "reason"),
+		healthy:          r.Gauge("lancache_dns_sync_healthy", "Whether the last sync succeeded."),
+		domains:          r.Gauge("lancache_dns_sync_domains", "Domains per service in the last successful sync.", "service"),
+		managedRules:     r.Gauge("lancache_dns_sync_managed_rules", "Managed rules after the last successful sync."),
+		downloadFailures: r.Counter("lancache_dns_sync_download_failures_total", "Domain files that could not be downloaded.", "file"),
+		requestDuration:  r.Histogram("lancache_dns_sync_adguard_request_duration_seconds", "Duration of the requests to AdGuard Home.", requestBuckets, "endpoint"),
+		requests:         r.Counter("lancache_dns_sync_adguard_requests_total", "Requests to AdGuard Home by status code, 0 when there was no response.", "endpoint", "code"),
+		adguardUp:        r.Gauge("lancache_dns_sync_adguard_up", "Whether the last request to AdGuard Home got a response without a server error."),
+	}
+}
+
+// observeSync records the result of a sync.
+func (m *syncMetrics) observeSync(result service.SyncResult, err error) {
+	m.phaseDuration.Set(result.Timings.Fetch.Seconds(), "fetch")
+	m.phaseDuration.Set(result.Timings.Download.Seconds(), "download")
+	m.phaseDuration.Set(result.Timings.Apply.Seconds(), "apply")
+	m.phaseDuration.Set(result.Timings.Total.Seconds(), "total")
+	for _, file := range result.FilesFailed {
+		m.downloadFailures.Inc(file)
+	}
+
+	if err != nil {
+		m.failures.Inc(failureReason(err))
+		m.healthy.Set(0)
+		return
+	}
+
+	m.healthy.Set(1)
+	m.lastSuccess.Set(float64(result.StartedAt.Add(result.Timings.Total).Unix()))
+	m.domains.Reset()
+	for _, svc := range result.Services {
+		m.domains.Set(float64(svc.Domains), svc.Name)
+	}
+	rules := 0
+	for _, target := range result.Targets {
+		rules += target.Rules
+	}
+	m.managedRules.Set(float64(rules))
+}
+
+// observeRequest records a request to AdGuard, see client.RequestObserver.
+func (m *syncMetrics) observeRequest(endpoint string, statusCode int, duration time.Duration) {
+	m.requestDuration.Observe(duration.Seconds(), endpoint)
+	m.requests.Inc(endpoint, strconv.Itoa(statusCode))
+	if statusCode == 0 || statusCode >= 500 {
+		m.adguardUp.Set(0)
+	} else {
+		m.adguardUp.Set(1)
+	}
+}
+
+// failureReason names the reason of a failed sync like the exit codes.
+func failureReason(err error) string {
+	switch exitCode(err) {
+	case exitCanceled:
+		return "canceled"
+	case exitUnauthorized:
+		return "unauthorized"
+	case exitNotFound:
+		return "not_found"
+	case exitValidation:
+		return "validation"
+	case exitServer:
+		return "server_error"
+	case exitNetwork:
+		return "network"
+	case exitMassRemoval:
+		return "mass_removal"
+	case exitMarkers:
+		return "corrupted_markers"
+	default:
+		return "other"
+	}
+}
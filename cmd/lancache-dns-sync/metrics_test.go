@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skaronator/lancache-dns-sync/internal/client"
+	"github.com/skaronator/lancache-dns-sync/internal/service"
+)
+
+func TestSyncMetrics(t *testing.T) {
+	m := newSyncMetrics()
+
+	m.observeSync(service.SyncResult{
+		StartedAt: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		Services: []service.ServiceResult{
+			{Name: "steam", Domains: 12},
+			{Name: "wsus", Domains: 4},
+		},
+		FilesFailed: []string{"blizzard.txt"},
+		Timings:     service.Timings{Fetch: time.Second, Download: 2 * time.Second, Apply: 500 * time.Millisecond, Total: 4 * time.Second},
+		Targets:     []service.TargetResult{{Target: "user_rules", Rules: 16, Written: true}},
+	}, nil)
+	m.observeSync(service.SyncResult{FilesFailed: []string{"blizzard.txt"}}, fmt.Errorf("failed to apply rules: %w", client.ErrUnauthorized))
+	m.observeRequest("/control/filtering/status", 200, 50*time.Millisecond)
+	m.observeRequest("/control/filtering/set_rules", 0, 30*time.Second)
+
+	var b strings.Builder
+	if err := m.registry.Write(&b); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	output := b.String()
+
+	for _, expected := range []string{
+		`lancache_dns_sync_phase_duration_seconds{phase="download"} 0`,
+		"lancache_dns_sync_last_success_timestamp_seconds 1.735689604e+09",
+		`lancache_dns_sync_failures_total{reason="unauthorized"} 1`,
+		"lancache_dns_sync_healthy 0",
+		`lancache_dns_sync_domains{service="steam"} 12`,
+		`lancache_dns_sync_domains{service="wsus"} 4`,
+		"lancache_dns_sync_managed_rules 16",
+		`lancache_dns_sync_download_failures_total{file="blizzard.txt"} 2`,
+		`lancache_dns_sync_adguard_request_duration_seconds_bucket{endpoint="/control/filtering/status",le="0.05"} 1`,
+		`lancache_dns_sync_adguard_requests_total{endpoint="/control/filtering/status",code="200"} 1`,
+		`lancache_dns_sync_adguard_requests_total{endpoint="/control/filtering/set_rules",code="0"} 1`,
+		"lancache_dns_sync_adguard_up 0",
+	} {
+		if !strings.Contains(output, expected+"\n") {
+			t.Errorf("Expected %q in the metrics, got\n%s", expected, output)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	filePrefix = "user-rules-"
+	fileSuffix = ".json"
+	// timeFormat sorts lexically in chronological order
+	timeFormat = "20060102T150405.000Z"
+)
+
+// ErrNotFound is returned when a requested backup does not exist.
+var ErrNotFound = errors.New("backup not found")
+
+// Backup describes a stored copy of AdGuard's user rules.
+type Backup struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Rules     []string  `json:"rules"`
+}
+
+// Store keeps timestamped backups of the user rules in a directory.
+type Store struct {
+	dir       string
+	retention int
+	maxAge    time.Duration
+	now       func() time.Time
+}
+
+// NewStore creates a store in dir keeping at most retention backups.
+// Backups older than maxAge are removed as well unless maxAge is zero.
+func NewStore(dir string, retention int, maxAge time.Duration) *Store {
+	return &Store{
+		dir:       dir,
+		retention: retention,
+		maxAge:    maxAge,
+		now:       time.Now,
+	}
+}
+
+// Save writes a new backup of the rules and prunes old backups.
+func (s *Store) Save(rules []string) (*Backup, error) {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	// Backups taken in quick succession must not overwrite each other
+	createdAt := s.now().UTC().Truncate(time.Millisecond)
+	for {
+		_, err := os.Stat(filepath.Join(s.dir, filePrefix+createdAt.Format(timeFormat)+fileSuffix))
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		createdAt = createdAt.Add(time.Millisecond)
+	}
+
+	backup := &Backup{
+		Name:      filePrefix + createdAt.Format(timeFormat),
+		CreatedAt: createdAt,
+		Rules:     rules,
+	}
+
+	data, err := json.MarshalIndent(backup, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal backup: %w", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated backup
+	path := filepath.Join(s.dir, backup.Name+fileSuffix)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return nil, fmt.Errorf("failed to write backup: %w", err)
+	}
+	slog.Debug("Saved user rules backup", "name", backup.Name, "rules_count", len(rules))
+
+	if err := s.prune(); err != nil {
+		slog.Warn("Failed to prune old backups", "error", err)
+	}
+
+	return backup, nil
+}
+
+// List returns the names of all backups, newest first.
+func (s *Store) List() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(name, fileSuffix))
+	}
+
+	slices.Sort(names)
+	slices.Reverse(names)
+	return names, nil
+}
+
+// Load reads the backup with the given name.
+func (s *Store) Load(name string) (*Backup, error) {
+	if name != filepath.Base(name) || !strings.HasPrefix(name, filePrefix) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, name+fileSuffix))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup: %w", err)
+	}
+
+	var backup Backup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return nil, fmt.Errorf("failed to decode backup %s: %w", name, err)
+	}
+	return &backup, nil
+}
+
+func (s *Store) prune() error {
+	names, err := s.List()
+	if err != nil {
+		return err
+	}
+
+	for i, name := range names {
+		expired := false
+		if s.maxAge > 0 {
+			createdAt, err := time.Parse(timeFormat, strings.TrimPrefix(name, filePrefix))
+			expired = err == nil && s.now().Sub(createdAt) > s.maxAge
+		}
+		// Never remove the newest backup
+		if i == 0 || (i < s.retention && !expired) {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(s.dir, name+fileSuffix)); err != nil {
+			return fmt.Errorf("failed to remove backup %s: %w", name, err)
+		}
+		slog.Debug("Removed old user rules backup", "name", name)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package backup
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T, retention int, maxAge time.Duration) (*Store, *time.Time) {
+	t.Helper()
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := NewStore(t.TempDir(), retention, maxAge)
+	store.now = func() time.Time { return now }
+	return store, &now
+}
+
+func TestStoreSaveAndLoad(t *testing.T) {
+	store, _ := newTestStore(t, 10, 0)
+	rules := []string{"||custom.com^", "# lancache-dns-sync start", "# lancache-dns-sync end"}
+
+	saved, err := store.Save(rules)
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if saved.Name != "user-rules-20250101T120000.000Z" {
+		t.Errorf("Unexpected backup name %s", saved.Name)
+	}
+
+	loaded, err := store.Load(saved.Name)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !slices.Equal(loaded.Rules, rules) {
+		t.Errorf("Expected rules %v, got %v", rules, loaded.Rules)
+	}
+	if !loaded.CreatedAt.Equal(saved.CreatedAt) {
+		t.Errorf("Expected created at %v, got %v", saved.CreatedAt, loaded.CreatedAt)
+	}
+}
+
+func TestStoreLoadErrors(t *testing.T) {
+	store, _ := newTestStore(t, 10, 0)
+
+	for _, name := range []string{"user-rules-20250101T120000.000Z", "../etc/passwd", "other"} {
+		if _, err := store.Load(name); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Load(%s) error = %v, want ErrNotFound", name, err)
+		}
+	}
+}
+
+func TestStoreListEmpty(t *testing.T) {
+	store := NewStore(t.TempDir()+"/missing", 10, 0)
+
+	names, err := store.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(names) != 0 {
+		t.Errorf("Expected no backups, got %v", names)
+	}
+}
+
+func TestStoreRetention(t *testing.T) {
+	tests := []struct {
+		name      string
+		retention int
+		maxAge    time.Duration
+		expected  []string
+	}{
+		{
+			name:      "keep newest by count",
+			retention: 2,
+			expected: []string{
+				"user-rules-20250101T160000.000Z",
+				"user-rules-20250101T150000.000Z",
+			},
+		},
+		{
+			name:      "remove expired",
+			retention: 10,
+			maxAge:    90 * time.Minute,
+			expected: []string{
+				"user-rules-20250101T160000.000Z",
+				"user-rules-20250101T150000.000Z",
+			},
+		},
+		{
+			name:      "always keep newest",
+			retention: 10,
+			maxAge:    time.Millisecond,
+			expected: []string{
+				"user-rules-20250101T160000.000Z",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, now := newTestStore(t, tt.retention, tt.maxAge)
+
+			for range 5 {
+				if _, err := store.Save([]string{"||custom.com^"}); err != nil {
+					t.Fatalf("Save() error = %v", err)
+				}
+				*now = now.Add(time.Hour)
+			}
+			*now = now.Add(-time.Hour)
+
+			names, err := store.List()
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if !slices.Equal(names, tt.expected) {
+				t.Errorf("Expected backups %v, got %v", tt.expected, names)
+			}
+		})
+	}
+}
+
+func TestStoreSaveSameTime(t *testing.T) {
+	store, _ := newTestStore(t, 10, 0)
+
+	first, err := store.Save([]string{"||first.com^"})
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	second, err := store.Save([]string{"||second.com^"})
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	if first.Name == second.Name {
+		t.Fatalf("Expected distinct backup names, got %s twice", first.Name)
+	}
+
+	names, err := store.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if !slices.Equal(names, []string{second.Name, first.Name}) {
+		t.Errorf("Expected backups %v, got %v", []string{second.Name, first.Name}, names)
+	}
+}
@@ -5,9 +5,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/skaronator/lancache-dns-sync/internal/types"
@@ -16,6 +16,9 @@ import (
 type AdguardClient interface {
 	GetFilteringStatus(ctx context.Context) (*types.FilterStatus, error)
 	SetFilteringRules(ctx context.Context, rules []string) error
+	AddFilterURL(ctx context.Context, name, url string) error
+	RemoveFilterURL(ctx context.Context, url string) error
+	RefreshFilters(ctx context.Context) error
 }
 
 type HTTPAdguardClient struct {
@@ -23,10 +26,30 @@ type HTTPAdguardClient struct {
 	username   string
 	password   string
 	httpClient *http.Client
+
+	sessionAuth bool
+	loginMu     sync.Mutex
+	loggedIn    bool
+
+	observe RequestObserver
+}
+
+// Option customizes an HTTPAdguardClient.
+type Option func(*HTTPAdguardClient)
+
+// RequestObserver is called after every request to AdGuard with the endpoint,
+// the status code, 0 if there was no response, and the duration.
+type RequestObserver func(endpoint string, statusCode int, duration time.Duration)
+
+// WithRequestObserver reports every request to observe, e.g. for metrics.
+func WithRequestObserver(observe RequestObserver) Option {
+	return func(c *HTTPAdguardClient) {
+		c.observe = observe
+	}
 }
 
-func NewAdguardClient(baseURL, username, password string, timeout time.Duration) AdguardClient {
-	return &HTTPAdguardClient{
+func NewAdguardClient(baseURL, username, password string, timeout time.Duration, opts ...Option) AdguardClient {
+	c := &HTTPAdguardClient{
 		baseURL:  baseURL,
 		username: username,
 		password: password,
@@ -34,26 +57,69 @@ func NewAdguardClient(baseURL, username, password string, timeout time.Duration)
 			Timeout: timeout,
 		},
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *HTTPAdguardClient) makeRequest(ctx context.Context, method, endpoint string, body []byte) (*http.Response, error) {
+	if c.sessionAuth {
+		if err := c.ensureSession(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := c.doRequest(ctx, method, endpoint, body)
+	if err != nil || !c.sessionAuth || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// The session expired or AdGuard restarted, log in again and retry once
+	slog.Debug("AdGuard session rejected, logging in again", "endpoint", endpoint)
+	if closeErr := resp.Body.Close(); closeErr != nil {
+		slog.Error("Failed to close response body", "error", closeErr)
+	}
+	if err := c.renewSession(ctx); err != nil {
+		return nil, err
+	}
+	return c.doRequest(ctx, method, endpoint, body)
 }
 
-func (c *HTTPAdguardClient) makeRequest(ctx context.Context, method, endpoint string, body io.Reader) (*http.Response, error) {
+func (c *HTTPAdguardClient) doRequest(ctx context.Context, method, endpoint string, body []byte) (*http.Response, error) {
 	url := c.baseURL + endpoint
-	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	req.SetBasicAuth(c.username, c.password)
+	if !c.sessionAuth {
+		req.SetBasicAuth(c.username, c.password)
+	}
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.send(req, endpoint)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute request: %w", err)
+		return nil, newNetworkError(fmt.Sprintf("%s %s", method, endpoint), err)
 	}
 
 	return resp, nil
 }
 
+// send executes req and reports it to the observer.
+func (c *HTTPAdguardClient) send(req *http.Request, endpoint string) (*http.Response, error) {
+	start := time.Now()
+	resp, err := c.httpClient.Do(req)
+	if c.observe != nil {
+		statusCode := 0
+		if err == nil {
+			statusCode = resp.StatusCode
+		}
+		c.observe(endpoint, statusCode, time.Since(start))
+	}
+	return resp, err
+}
+
 func (c *HTTPAdguardClient) GetFilteringStatus(ctx context.Context) (*types.FilterStatus, error) {
 	resp, err := c.makeRequest(ctx, "GET", "/control/filtering/status", nil)
 	if err != nil {
@@ -66,7 +132,7 @@ func (c *HTTPAdguardClient) GetFilteringStatus(ctx context.Context) (*types.Filt
 	}()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get filtering status: status %d", resp.StatusCode)
+		return nil, newStatusError("failed to get filtering status", resp)
 	}
 
 	var status types.FilterStatus
@@ -84,7 +150,7 @@ func (c *HTTPAdguardClient) SetFilteringRules(ctx context.Context, rules []strin
 		return fmt.Errorf("failed to marshal rules request: %w", err)
 	}
 
-	resp, err := c.makeRequest(ctx, "POST", "/control/filtering/set_rules", bytes.NewBuffer(jsonData))
+	resp, err := c.makeRequest(ctx, "POST", "/control/filtering/set_rules", jsonData)
 	if err != nil {
 		return fmt.Errorf("failed to set filtering rules: %w", err)
 	}
@@ -95,7 +161,79 @@ func (c *HTTPAdguardClient) SetFilteringRules(ctx context.Context, rules []strin
 	}()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to set filtering rules: status %d", resp.StatusCode)
+		return newStatusError("failed to set filtering rules", resp)
+	}
+
+	return nil
+}
+
+func (c *HTTPAdguardClient) AddFilterURL(ctx context.Context, name, url string) error {
+	request := types.AddURLRequest{Name: name, URL: url}
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal add url request: %w", err)
+	}
+
+	resp, err := c.makeRequest(ctx, "POST", "/control/filtering/add_url", jsonData)
+	if err != nil {
+		return fmt.Errorf("failed to add filter url: %w", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			slog.Error("Failed to close response body", "error", closeErr)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError("failed to add filter url", resp)
+	}
+
+	return nil
+}
+
+func (c *HTTPAdguardClient) RemoveFilterURL(ctx context.Context, url string) error {
+	request := types.RemoveURLRequest{URL: url}
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal remove url request: %w", err)
+	}
+
+	resp, err := c.makeRequest(ctx, "POST", "/control/filtering/remove_url", jsonData)
+	if err != nil {
+		return fmt.Errorf("failed to remove filter url: %w", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			slog.Error("Failed to close response body", "error", closeErr)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError("failed to remove filter url", resp)
+	}
+
+	return nil
+}
+
+func (c *HTTPAdguardClient) RefreshFilters(ctx context.Context) error {
+	request := types.RefreshRequest{Whitelist: false}
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal refresh request: %w", err)
+	}
+
+	resp, err := c.makeRequest(ctx, "POST", "/control/filtering/refresh", jsonData)
+	if err != nil {
+		return fmt.Errorf("failed to refresh filters: %w", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			slog.Error("Failed to close response body", "error", closeErr)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError("failed to refresh filters", resp)
 	}
 
 	return nil
@@ -3,8 +3,11 @@ package client
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -139,3 +142,180 @@ func TestHTTPAdguardClientSetFilteringRules(t *testing.T) {
 		t.Errorf("Expected no error, got %v", err)
 	}
 }
+
+func TestHTTPAdguardClientErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		statusCode   int
+		body         string
+		expectedKind error
+	}{
+		{"unauthorized", http.StatusUnauthorized, "Unauthorized", ErrUnauthorized},
+		{"forbidden", http.StatusForbidden, "Forbidden", ErrUnauthorized},
+		{"not found", http.StatusNotFound, "404 page not found", ErrNotFound},
+		{"validation", http.StatusBadRequest, "line 3: invalid rule", ErrValidation},
+		{"server error", http.StatusInternalServerError, "filtering engine failed", ErrServer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statusCode)
+				if _, err := w.Write([]byte(tt.body)); err != nil {
+					t.Errorf("Failed to write response: %v", err)
+				}
+			}))
+			defer server.Close()
+
+			client := NewAdguardClient(server.URL, "admin", "password", 30*time.Second)
+
+			_, getErr := client.GetFilteringStatus(context.Background())
+			setErr := client.SetFilteringRules(context.Background(), []string{"||example.com^"})
+
+			for _, err := range []error{getErr, setErr} {
+				if !errors.Is(err, tt.expectedKind) {
+					t.Errorf("Expected error kind %v, got %v", tt.expectedKind, err)
+				}
+
+				var apiErr *APIError
+				if !errors.As(err, &apiErr) {
+					t.Fatalf("Expected APIError, got %T", err)
+				}
+				if apiErr.StatusCode != tt.statusCode {
+					t.Errorf("Expected status %d, got %d", tt.statusCode, apiErr.StatusCode)
+				}
+				if apiErr.Body != tt.body {
+					t.Errorf("Expected body %q, got %q", tt.body, apiErr.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPAdguardClientErrorBodyTruncated(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		if _, err := w.Write([]byte(strings.Repeat("x", 4096))); err != nil {
+			t.Errorf("Failed to write response: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	client := NewAdguardClient(server.URL, "admin", "password", 30*time.Second)
+	err := client.SetFilteringRules(context.Background(), []string{"||example.com^"})
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected APIError, got %v", err)
+	}
+	if len(apiErr.Body) != maxErrorBodySize+len("...") {
+		t.Errorf("Expected body to be truncated to %d bytes, got %d", maxErrorBodySize, len(apiErr.Body))
+	}
+}
+
+func TestHTTPAdguardClientNetworkError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+
+	client := NewAdguardClient(server.URL, "admin", "password", 30*time.Second)
+	_, err := client.GetFilteringStatus(context.Background())
+
+	if !errors.Is(err, ErrNetwork) {
+		t.Errorf("Expected network error, got %v", err)
+	}
+}
+
+func TestHTTPAdguardClientRequestObserver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	type observation struct {
+		endpoint   string
+		statusCode int
+	}
+	var observed []observation
+	client := NewAdguardClient(server.URL, "admin", "password", 30*time.Second, WithRequestObserver(func(endpoint string, statusCode int, duration time.Duration) {
+		observed = append(observed, observation{endpoint, statusCode})
+	}))
+
+	if err := client.RefreshFilters(context.Background()); err == nil {
+		t.Error("Expected error but got none")
+	}
+	server.Close()
+	if _, err := client.GetFilteringStatus(context.Background()); err == nil {
+		t.Error("Expected error but got none")
+	}
+
+	expected := []observation{
+		{"/control/filtering/refresh", http.StatusInternalServerError},
+		{"/control/filtering/status", 0},
+	}
+	if !slices.Equal(observed, expected) {
+		t.Errorf("Expected observations %v, got %v", expected, observed)
+	}
+}
+
+func TestHTTPAdguardClientAddFilterURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/control/filtering/add_url" {
+			t.Errorf("Expected path /control/filtering/add_url, got %s", r.URL.Path)
+		}
+
+		var request types.AddURLRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("Failed to decode request body: %v", err)
+		}
+		if request.Name != "lancache" || request.URL != "http://sync:8080/filter.txt" || request.Whitelist {
+			t.Errorf("Unexpected request %+v", request)
+		}
+	}))
+	defer server.Close()
+
+	client := NewAdguardClient(server.URL, "admin", "password", 30*time.Second)
+	if err := client.AddFilterURL(context.Background(), "lancache", "http://sync:8080/filter.txt"); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestHTTPAdguardClientRefreshFilters(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/control/filtering/refresh" {
+			t.Errorf("Expected path /control/filtering/refresh, got %s", r.URL.Path)
+		}
+		if r.Method != "POST" {
+			t.Errorf("Expected POST method, got %s", r.Method)
+		}
+		if _, err := w.Write([]byte(`{"updated":1}`)); err != nil {
+			t.Errorf("Failed to write response: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	client := NewAdguardClient(server.URL, "admin", "password", 30*time.Second)
+	if err := client.RefreshFilters(context.Background()); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestHTTPAdguardClientRemoveFilterURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/control/filtering/remove_url" {
+			t.Errorf("Expected path /control/filtering/remove_url, got %s", r.URL.Path)
+		}
+
+		var request types.RemoveURLRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("Failed to decode request body: %v", err)
+		}
+		if request.URL != "http://sync:8080/filter.txt" || request.Whitelist {
+			t.Errorf("Unexpected request %+v", request)
+		}
+	}))
+	defer server.Close()
+
+	client := NewAdguardClient(server.URL, "admin", "password", 30*time.Second)
+	if err := client.RemoveFilterURL(context.Background(), "http://sync:8080/filter.txt"); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxErrorBodySize limits how much of an error response body is kept.
+const maxErrorBodySize = 512
+
+var (
+	ErrUnauthorized = errors.New("authentication failed")
+	ErrNotFound     = errors.New("endpoint not found")
+	ErrValidation   = errors.New("request rejected")
+	ErrServer       = errors.New("server error")
+	ErrNetwork      = errors.New("network error")
+)
+
+// APIError describes a failed AdGuard API call. It matches one of the
+// Err* sentinels with errors.Is so callers can branch on the kind of failure.
+type APIError struct {
+	Kind       error
+	Operation  string
+	StatusCode int
+	Body       string
+	Err        error
+}
+
+func (e *APIError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %v", e.Operation, e.Kind)
+	if e.StatusCode != 0 {
+		fmt.Fprintf(&b, " (status %d)", e.StatusCode)
+	}
+	if e.Body != "" {
+		fmt.Fprintf(&b, ": %s", e.Body)
+	}
+	if e.Err != nil {
+		fmt.Fprintf(&b, ": %v", e.Err)
+	}
+	return b.String()
+}
+
+func (e *APIError) Unwrap() []error {
+	if e.Err != nil {
+		return []error{e.Kind, e.Err}
+	}
+	return []error{e.Kind}
+}
+
+func newNetworkError(operation string, err error) *APIError {
+	return &APIError{Kind: ErrNetwork, Operation: operation, Err: err}
+}
+
+// newStatusError builds an APIError from a non-200 response, keeping a
+// truncated copy of the body AdGuard sent to explain the failure.
+func newStatusError(operation string, resp *http.Response) *APIError {
+	var kind error
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		kind = ErrUnauthorized
+	case resp.StatusCode == http.StatusNotFound:
+		kind = ErrNotFound
+	case resp.StatusCode >= 500:
+		kind = ErrServer
+	default:
+		kind = ErrValidation
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize+1))
+	text := strings.TrimSpace(string(body))
+	if len(body) > maxErrorBodySize {
+		text = strings.TrimSpace(string(body[:maxErrorBodySize])) + "..."
+	}
+
+	return &APIError{
+		Kind:       kind,
+		Operation:  operation,
+		StatusCode: resp.StatusCode,
+		Body:       text,
+	}
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+)
+
+type loginRequest struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+// WithSessionAuth makes the client log in through /control/login and reuse
+// the session cookie instead of sending basic auth with every request.
+func WithSessionAuth() Option {
+	return func(c *HTTPAdguardClient) {
+		// cookiejar.New never returns an error without options
+		jar, _ := cookiejar.New(nil)
+		c.httpClient.Jar = jar
+		c.sessionAuth = true
+	}
+}
+
+// ensureSession logs in unless a session already exists.
+func (c *HTTPAdguardClient) ensureSession(ctx context.Context) error {
+	c.loginMu.Lock()
+	defer c.loginMu.Unlock()
+
+	if c.loggedIn {
+		return nil
+	}
+	return c.login(ctx)
+}
+
+// renewSession replaces a session AdGuard no longer accepts.
+func (c *HTTPAdguardClient) renewSession(ctx context.Context) error {
+	c.loginMu.Lock()
+	defer c.loginMu.Unlock()
+
+	c.loggedIn = false
+	return c.login(ctx)
+}
+
+// login creates a new session. The cookie AdGuard returns is stored in the
+// client's cookie jar and sent with all following requests. The caller must
+// hold loginMu.
+func (c *HTTPAdguardClient) login(ctx context.Context) error {
+	jsonData, err := json.Marshal(loginRequest{Name: c.username, Password: c.password})
+	if err != nil {
+		return fmt.Errorf("failed to marshal login request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/control/login", bytes.NewReader(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.send(req, "/control/login")
+	if err != nil {
+		return newNetworkError("POST /control/login", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			slog.Error("Failed to close response body", "error", closeErr)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError("failed to log in", resp)
+	}
+
+	c.loggedIn = true
+	slog.Debug("Logged in to AdGuard")
+	return nil
+}
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/skaronator/lancache-dns-sync/internal/types"
+)
+
+func TestHTTPAdguardClientSessionAuth(t *testing.T) {
+	var logins atomic.Int32
+	var session atomic.Value
+	session.Store("")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := r.BasicAuth(); ok {
+			t.Error("Expected no basic auth in session mode")
+		}
+
+		if r.URL.Path == "/control/login" {
+			var request loginRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				t.Errorf("Failed to decode login request: %v", err)
+			}
+			if request.Name != "admin" || request.Password != "password" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			id := fmt.Sprintf("session-%d", logins.Add(1))
+			session.Store(id)
+			http.SetCookie(w, &http.Cookie{Name: "agh_session", Value: id, Path: "/"})
+			return
+		}
+
+		cookie, err := r.Cookie("agh_session")
+		if err != nil || cookie.Value != session.Load().(string) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(types.FilterStatus{Enabled: true}); err != nil {
+			t.Errorf("Failed to encode status: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	client := NewAdguardClient(server.URL, "admin", "password", 30*time.Second, WithSessionAuth())
+
+	for range 2 {
+		if _, err := client.GetFilteringStatus(context.Background()); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if logins.Load() != 1 {
+		t.Errorf("Expected session to be reused, got %d logins", logins.Load())
+	}
+
+	// Invalidate the session on the server side
+	session.Store("expired")
+	if _, err := client.GetFilteringStatus(context.Background()); err != nil {
+		t.Fatalf("Expected re-authentication to succeed, got %v", err)
+	}
+	if logins.Load() != 2 {
+		t.Errorf("Expected 2 logins after session expiry, got %d", logins.Load())
+	}
+}
+
+func TestHTTPAdguardClientSessionAuthInvalidCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	client := NewAdguardClient(server.URL, "admin", "wrong", 30*time.Second, WithSessionAuth())
+	_, err := client.GetFilteringStatus(context.Background())
+
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Expected authentication error, got %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+// TLSOptions configures how the AdGuard client verifies the server and
+// authenticates itself when the API is served over HTTPS.
+type TLSOptions struct {
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// IsZero reports whether no TLS option is set.
+func (o TLSOptions) IsZero() bool {
+	return o == TLSOptions{}
+}
+
+// LoadTLSConfig builds a tls.Config from the options, reading the CA bundle
+// and client certificate from disk.
+func LoadTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: opts.ServerName,
+	}
+
+	if opts.CAFile != "" {
+		caData, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("no PEM certificates found in CA file %s", opts.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return nil, errors.New("client certificate and key must be specified together")
+	}
+	if opts.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if opts.InsecureSkipVerify {
+		slog.Warn("TLS certificate verification for the AdGuard API is disabled")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	return tlsConfig, nil
+}
+
+// WithTLSConfig makes the client use the given TLS configuration for all
+// requests to AdGuard.
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return func(c *HTTPAdguardClient) {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		c.httpClient.Transport = transport
+	}
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/skaronator/lancache-dns-sync/internal/types"
+)
+
+func newTLSTestServer(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewEncoder(w).Encode(types.FilterStatus{Enabled: true}); err != nil {
+			t.Errorf("Failed to encode status: %v", err)
+		}
+	}))
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, caData, 0o600); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+
+	return server, caFile
+}
+
+func TestLoadTLSConfig(t *testing.T) {
+	server, caFile := newTLSTestServer(t)
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		opts        TLSOptions
+		expectError error
+	}{
+		{
+			name:        "untrusted certificate",
+			opts:        TLSOptions{},
+			expectError: ErrNetwork,
+		},
+		{
+			name: "custom CA bundle",
+			opts: TLSOptions{CAFile: caFile},
+		},
+		{
+			name: "custom CA bundle with server name",
+			opts: TLSOptions{CAFile: caFile, ServerName: "example.com"},
+		},
+		{
+			name:        "custom CA bundle with wrong server name",
+			opts:        TLSOptions{CAFile: caFile, ServerName: "adguard.internal"},
+			expectError: ErrNetwork,
+		},
+		{
+			name: "insecure skip verify",
+			opts: TLSOptions{InsecureSkipVerify: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsConfig, err := LoadTLSConfig(tt.opts)
+			if err != nil {
+				t.Fatalf("LoadTLSConfig() error = %v", err)
+			}
+
+			client := NewAdguardClient(server.URL, "admin", "password", 5*time.Second, WithTLSConfig(tlsConfig))
+			_, err = client.GetFilteringStatus(context.Background())
+
+			if tt.expectError == nil && err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+			if tt.expectError != nil && !errors.Is(err, tt.expectError) {
+				t.Errorf("Expected error %v, got %v", tt.expectError, err)
+			}
+		})
+	}
+}
+
+func TestLoadTLSConfigErrors(t *testing.T) {
+	invalidFile := filepath.Join(t.TempDir(), "invalid.pem")
+	if err := os.WriteFile(invalidFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		opts TLSOptions
+	}{
+		{"missing CA file", TLSOptions{CAFile: "/nonexistent/ca.pem"}},
+		{"invalid CA file", TLSOptions{CAFile: invalidFile}},
+		{"certificate without key", TLSOptions{CertFile: invalidFile}},
+		{"invalid key pair", TLSOptions{CertFile: invalidFile, KeyFile: invalidFile}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadTLSConfig(tt.opts); err == nil {
+				t.Error("Expected error but got none")
+			}
+		})
+	}
+}
@@ -7,9 +7,11 @@ import (
 	"net/url"
 	"os"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/skaronator/lancache-dns-sync/internal/ruletemplate"
 	"github.com/skaronator/lancache-dns-sync/internal/scheduler"
 )
 
@@ -18,34 +20,178 @@ type Config struct {
 	Password       string
 	LancacheServer net.IP
 	AdguardAPI     *url.URL
+	AdguardTLS     TLSConfig
+	AuthMode       string
 	ServiceNames   []string
-	SyncInterval   time.Duration
-	Timeout        time.Duration
+	ClientTags     map[string][]string
+	RuleTemplates  RuleTemplates
+	// InstanceID distinguishes the managed sections of several instances
+	// writing to the same AdGuard.
+	InstanceID   string
+	SyncMode     string
+	FilterList   FilterListConfig
+	SyncInterval time.Duration
+	// Schedule decides when syncs run in daemon mode, every SyncInterval by default.
+	Schedule scheduler.Schedule
+	// EventWindows restrict the managed rules to these windows, outside of them
+	// syncs remove the rules. Empty means always active.
+	EventWindows scheduler.Windows
+	// SyncJitter delays scheduled syncs by a random duration up to this value.
+	SyncJitter time.Duration
+	// RetryBackoff configures the retries of failed scheduled syncs.
+	RetryBackoff scheduler.Backoff
+	Timeout      time.Duration
+
+	RemovalLimits RemovalLimits
+	// AnomalyShrinkPercent quarantines a service when its domains shrink by
+	// more than this percentage between syncs, 0 disables the check.
+	AnomalyShrinkPercent float64
+
+	// DryRun prints the changes a sync would make instead of writing them.
+	DryRun bool
+
+	// MetricsListen is the address the Prometheus metrics are served on in
+	// daemon mode. Empty disables the metrics.
+	MetricsListen string
+	// ControlToken authorizes the pause and resume endpoints of the HTTP
+	// servers. Empty disables the endpoints.
+	ControlToken string
+
+	// StateDir stores backups and sync state. Empty disables both.
+	StateDir        string
+	BackupRetention int
+	BackupMaxAge    time.Duration
+}
+
+// RuleTemplates render the rules of exact and wildcard domains. A nil
+// template uses the default format.
+type RuleTemplates struct {
+	Exact    *ruletemplate.Template
+	Wildcard *ruletemplate.Template
 }
 
+// TLSConfig holds the TLS settings for the AdGuard API connection.
+type TLSConfig struct {
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+const (
+	DefaultTimeout         = 30 * time.Second
+	DefaultBackupRetention = 10
+)
+
+// FilterListConfig configures the built-in filter list server used in
+// SyncModeFilterList.
+type FilterListConfig struct {
+	// Listen is the address the filter list server listens on.
+	Listen string
+	// URL is the address AdGuard uses to download the filter list.
+	URL  string
+	Name string
+}
+
+// RemovalLimits restricts how many managed rules a single sync may remove.
+type RemovalLimits struct {
+	// MaxPercent of the existing managed rules that may be removed, 0 disables the check.
+	MaxPercent float64
+	// MaxCount of managed rules that may be removed, 0 disables the check.
+	MaxCount int
+	// Override allows exceeding the limits.
+	Override bool
+}
+
+const (
+	DefaultMaxRemovalPercent    = 50
+	DefaultAnomalyShrinkPercent = 50
+)
+
+const (
+	// SyncModeUserRules writes the managed rules into AdGuard's custom user rules.
+	SyncModeUserRules = "user_rules"
+	// SyncModeFilterList serves the managed rules as a filter list AdGuard subscribes to.
+	SyncModeFilterList = "filter_list"
+
+	DefaultFilterListListen = ":8080"
+	DefaultFilterListName   = "lancache-dns-sync"
+)
+
 const (
-	DefaultTimeout = 30 * time.Second
+	// AuthModeBasic sends basic auth credentials with every request.
+	AuthModeBasic = "basic"
+	// AuthModeSession logs in once through /control/login and reuses the session cookie.
+	AuthModeSession = "session"
 )
 
+// knownClientTags lists the client tags AdGuard Home accepts in $ctag modifiers.
+var knownClientTags = []string{
+	"device_audio", "device_camera", "device_gameconsole", "device_laptop",
+	"device_nas", "device_other", "device_pc", "device_phone", "device_printer",
+	"device_securityalarm", "device_tablet", "device_tv",
+	"os_android", "os_ios", "os_linux", "os_macos", "os_other", "os_windows",
+	"user_admin", "user_child", "user_regular",
+}
+
+// Load reads the configuration from the environment. If CONFIG_FILE names a
+// file of KEY=VALUE lines, its values take precedence over the environment,
+// so changes to it can be applied by loading again.
 func Load() (*Config, error) {
+	getenv := os.Getenv
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		values, err := readConfigFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CONFIG_FILE: %w", err)
+		}
+		getenv = func(name string) string {
+			if value, ok := values[name]; ok {
+				return value
+			}
+			return os.Getenv(name)
+		}
+	}
+	return load(getenv)
+}
+
+func load(getenv func(string) string) (*Config, error) {
 	config := &Config{
-		SyncInterval: scheduler.DefaultSyncInterval,
-		Timeout:      DefaultTimeout,
+		SyncInterval:    scheduler.DefaultSyncInterval,
+		RetryBackoff:    scheduler.DefaultBackoff,
+		Timeout:         DefaultTimeout,
+		AuthMode:        AuthModeBasic,
+		SyncMode:        SyncModeUserRules,
+		BackupRetention: DefaultBackupRetention,
+		RemovalLimits: RemovalLimits{
+			MaxPercent: DefaultMaxRemovalPercent,
+		},
+		AnomalyShrinkPercent: DefaultAnomalyShrinkPercent,
+		FilterList: FilterListConfig{
+			Listen: DefaultFilterListListen,
+			Name:   DefaultFilterListName,
+		},
 	}
 
-	username := os.Getenv("ADGUARD_USERNAME")
+	username, err := getSecret(getenv, "ADGUARD_USERNAME")
+	if err != nil {
+		return nil, err
+	}
 	if username == "" {
-		return nil, errors.New("ADGUARD_USERNAME environment variable is required")
+		return nil, errors.New("ADGUARD_USERNAME or ADGUARD_USERNAME_FILE environment variable is required")
 	}
 	config.Username = username
 
-	password := os.Getenv("ADGUARD_PASSWORD")
+	password, err := getSecret(getenv, "ADGUARD_PASSWORD")
+	if err != nil {
+		return nil, err
+	}
 	if password == "" {
-		return nil, errors.New("ADGUARD_PASSWORD environment variable is required")
+		return nil, errors.New("ADGUARD_PASSWORD or ADGUARD_PASSWORD_FILE environment variable is required")
 	}
 	config.Password = password
 
-	lancacheServerStr := os.Getenv("LANCACHE_SERVER")
+	lancacheServerStr := getenv("LANCACHE_SERVER")
 	if lancacheServerStr == "" {
 		return nil, errors.New("LANCACHE_SERVER environment variable is required")
 	}
@@ -55,7 +201,7 @@ func Load() (*Config, error) {
 	}
 	config.LancacheServer = lancacheServer
 
-	adguardAPIStr := os.Getenv("ADGUARD_API")
+	adguardAPIStr := getenv("ADGUARD_API")
 	if adguardAPIStr == "" {
 		return nil, errors.New("ADGUARD_API environment variable is required")
 	}
@@ -68,7 +214,31 @@ func Load() (*Config, error) {
 	}
 	config.AdguardAPI = adguardAPI
 
-	serviceNamesStr := os.Getenv("SERVICE_NAMES")
+	if authMode := getenv("ADGUARD_AUTH_MODE"); authMode != "" {
+		if authMode != AuthModeBasic && authMode != AuthModeSession {
+			return nil, fmt.Errorf("invalid ADGUARD_AUTH_MODE: %s (must be %s or %s)", authMode, AuthModeBasic, AuthModeSession)
+		}
+		config.AuthMode = authMode
+	}
+
+	config.AdguardTLS = TLSConfig{
+		CAFile:     getenv("ADGUARD_TLS_CA_FILE"),
+		CertFile:   getenv("ADGUARD_TLS_CERT_FILE"),
+		KeyFile:    getenv("ADGUARD_TLS_KEY_FILE"),
+		ServerName: getenv("ADGUARD_TLS_SERVER_NAME"),
+	}
+	if (config.AdguardTLS.CertFile == "") != (config.AdguardTLS.KeyFile == "") {
+		return nil, errors.New("ADGUARD_TLS_CERT_FILE and ADGUARD_TLS_KEY_FILE must be specified together")
+	}
+	if insecureStr := getenv("ADGUARD_TLS_INSECURE_SKIP_VERIFY"); insecureStr != "" {
+		insecure, err := strconv.ParseBool(insecureStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ADGUARD_TLS_INSECURE_SKIP_VERIFY: %w", err)
+		}
+		config.AdguardTLS.InsecureSkipVerify = insecure
+	}
+
+	serviceNamesStr := getenv("SERVICE_NAMES")
 	if serviceNamesStr == "" {
 		return nil, errors.New("SERVICE_NAMES must be specified (use '*' for all services)")
 	}
@@ -86,17 +256,300 @@ func Load() (*Config, error) {
 	}
 	config.ServiceNames = serviceNames
 
-	if syncIntervalStr := os.Getenv("SYNC_INTERVAL"); syncIntervalStr != "" {
+	if clientTagsStr := getenv("CLIENT_TAGS"); clientTagsStr != "" {
+		clientTags, err := parseClientTags(clientTagsStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CLIENT_TAGS: %w", err)
+		}
+		config.ClientTags = clientTags
+	}
+
+	if text := getenv("RULE_TEMPLATE_EXACT"); text != "" {
+		tmpl, err := ruletemplate.Parse("exact", text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RULE_TEMPLATE_EXACT: %w", err)
+		}
+		config.RuleTemplates.Exact = tmpl
+	}
+
+	if text := getenv("RULE_TEMPLATE_WILDCARD"); text != "" {
+		tmpl, err := ruletemplate.Parse("wildcard", text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RULE_TEMPLATE_WILDCARD: %w", err)
+		}
+		config.RuleTemplates.Wildcard = tmpl
+	}
+
+	if syncMode := getenv("SYNC_MODE"); syncMode != "" {
+		if syncMode != SyncModeUserRules && syncMode != SyncModeFilterList {
+			return nil, fmt.Errorf("invalid SYNC_MODE: %s (must be %s or %s)", syncMode, SyncModeUserRules, SyncModeFilterList)
+		}
+		config.SyncMode = syncMode
+	}
+
+	if config.SyncMode == SyncModeFilterList {
+		filterListURLStr := getenv("FILTER_LIST_URL")
+		if filterListURLStr == "" {
+			return nil, errors.New("FILTER_LIST_URL environment variable is required in filter_list mode")
+		}
+		filterListURL, err := url.Parse(filterListURLStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FILTER_LIST_URL: %w", err)
+		}
+		if filterListURL.Scheme != "http" && filterListURL.Scheme != "https" {
+			return nil, errors.New("FILTER_LIST_URL must use http or https scheme")
+		}
+		config.FilterList.URL = filterListURLStr
+
+		if listen := getenv("FILTER_LIST_LISTEN"); listen != "" {
+			config.FilterList.Listen = listen
+		}
+		if name := getenv("FILTER_LIST_NAME"); name != "" {
+			config.FilterList.Name = name
+		}
+	}
+
+	config.MetricsListen = getenv("METRICS_LISTEN")
+
+	controlToken, err := getSecret(getenv, "CONTROL_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	config.ControlToken = controlToken
+
+	if percentStr := getenv("MAX_REMOVAL_PERCENT"); percentStr != "" {
+		percent, err := strconv.ParseFloat(percentStr, 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return nil, fmt.Errorf("invalid MAX_REMOVAL_PERCENT: %s (must be between 0 and 100)", percentStr)
+		}
+		config.RemovalLimits.MaxPercent = percent
+	}
+
+	if countStr := getenv("MAX_REMOVAL_COUNT"); countStr != "" {
+		count, err := strconv.Atoi(countStr)
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("invalid MAX_REMOVAL_COUNT: %s (must be a non-negative number)", countStr)
+		}
+		config.RemovalLimits.MaxCount = count
+	}
+
+	if overrideStr := getenv("ALLOW_MASS_REMOVAL"); overrideStr != "" {
+		override, err := strconv.ParseBool(overrideStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ALLOW_MASS_REMOVAL: %w", err)
+		}
+		config.RemovalLimits.Override = override
+	}
+
+	if shrinkStr := getenv("ANOMALY_SHRINK_PERCENT"); shrinkStr != "" {
+		shrink, err := strconv.ParseFloat(shrinkStr, 64)
+		if err != nil || shrink < 0 || shrink > 100 {
+			return nil, fmt.Errorf("invalid ANOMALY_SHRINK_PERCENT: %s (must be between 0 and 100)", shrinkStr)
+		}
+		config.AnomalyShrinkPercent = shrink
+	}
+
+	if instanceID := getenv("INSTANCE_ID"); instanceID != "" {
+		if !isValidInstanceID(instanceID) {
+			return nil, fmt.Errorf("invalid INSTANCE_ID: %s (only letters, digits, '-' and '_' are allowed)", instanceID)
+		}
+		config.InstanceID = instanceID
+	}
+
+	config.StateDir = getenv("STATE_DIR")
+
+	if retentionStr := getenv("BACKUP_RETENTION"); retentionStr != "" {
+		retention, err := strconv.Atoi(retentionStr)
+		if err != nil || retention < 1 {
+			return nil, fmt.Errorf("invalid BACKUP_RETENTION: %s (must be a positive number)", retentionStr)
+		}
+		config.BackupRetention = retention
+	}
+
+	if maxAgeStr := getenv("BACKUP_MAX_AGE"); maxAgeStr != "" {
+		maxAge, err := time.ParseDuration(maxAgeStr)
+		if err != nil || maxAge <= 0 {
+			return nil, fmt.Errorf("invalid BACKUP_MAX_AGE: %s", maxAgeStr)
+		}
+		config.BackupMaxAge = maxAge
+	}
+
+	if syncIntervalStr := getenv("SYNC_INTERVAL"); syncIntervalStr != "" {
 		syncInterval, err := scheduler.ParseSyncInterval(syncIntervalStr)
 		if err != nil {
 			return nil, fmt.Errorf("invalid SYNC_INTERVAL: %w", err)
 		}
 		config.SyncInterval = syncInterval
 	}
+	config.Schedule = scheduler.Interval(config.SyncInterval)
+
+	location := time.Local
+	if timezone := getenv("SYNC_TIMEZONE"); timezone != "" {
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SYNC_TIMEZONE: %w", err)
+		}
+		location = loc
+	}
+
+	if scheduleStr := getenv("SYNC_SCHEDULE"); scheduleStr != "" {
+		if getenv("SYNC_INTERVAL") != "" {
+			return nil, errors.New("both SYNC_SCHEDULE and SYNC_INTERVAL are set, use only one")
+		}
+		schedule, err := scheduler.ParseSchedule(scheduleStr, location)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SYNC_SCHEDULE: %w", err)
+		}
+		config.Schedule = schedule
+	}
+
+	if windowsStr := getenv("EVENT_WINDOWS"); windowsStr != "" {
+		windows, err := scheduler.ParseWindows(windowsStr, location)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EVENT_WINDOWS: %w", err)
+		}
+		config.EventWindows = windows
+		config.Schedule = scheduler.InWindows(config.Schedule, windows)
+	}
+
+	if timeoutStr := getenv("TIMEOUT"); timeoutStr != "" {
+		timeout, err := time.ParseDuration(timeoutStr)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid TIMEOUT: %s", timeoutStr)
+		}
+		config.Timeout = timeout
+	}
+
+	if jitterStr := getenv("SYNC_JITTER"); jitterStr != "" {
+		jitter, err := time.ParseDuration(jitterStr)
+		if err != nil || jitter < 0 {
+			return nil, fmt.Errorf("invalid SYNC_JITTER: %s", jitterStr)
+		}
+		config.SyncJitter = jitter
+	}
+
+	if retryMinStr := getenv("SYNC_RETRY_MIN"); retryMinStr != "" {
+		retryMin, err := time.ParseDuration(retryMinStr)
+		if err != nil || retryMin <= 0 {
+			return nil, fmt.Errorf("invalid SYNC_RETRY_MIN: %s", retryMinStr)
+		}
+		config.RetryBackoff.Min = retryMin
+	}
+
+	if retryMaxStr := getenv("SYNC_RETRY_MAX"); retryMaxStr != "" {
+		retryMax, err := time.ParseDuration(retryMaxStr)
+		if err != nil || retryMax <= 0 {
+			return nil, fmt.Errorf("invalid SYNC_RETRY_MAX: %s", retryMaxStr)
+		}
+		config.RetryBackoff.Max = retryMax
+	}
+
+	if config.RetryBackoff.Min > config.RetryBackoff.Max {
+		return nil, fmt.Errorf("SYNC_RETRY_MIN (%s) must not be greater than SYNC_RETRY_MAX (%s)", config.RetryBackoff.Min, config.RetryBackoff.Max)
+	}
 
 	return config, nil
 }
 
+// getSecret reads a value either directly from the environment variable name
+// or from the file referenced by name_FILE, following the Docker and
+// Kubernetes secrets convention. Trailing newlines in the file are trimmed.
+func getSecret(getenv func(string) string, name string) (string, error) {
+	value := getenv(name)
+	path := getenv(name + "_FILE")
+	if path == "" {
+		return value, nil
+	}
+	if value != "" {
+		return "", fmt.Errorf("both %s and %s_FILE are set, use only one", name, name)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s_FILE: %w", name, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// readConfigFile reads a file of KEY=VALUE lines in the format of Docker env
+// files. Blank lines and lines starting with # are ignored, an "export "
+// prefix and quotes around the value are allowed.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", i+1)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+func isValidInstanceID(id string) bool {
+	for _, r := range id {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '-' && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseClientTags parses a list like "steam=device_pc|device_gameconsole,*=device_pc"
+// into a map of service name to client tags. The service "*" applies to every
+// service without an explicit entry.
+func parseClientTags(value string) (map[string][]string, error) {
+	clientTags := make(map[string][]string)
+	for entry := range strings.SplitSeq(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		service, tagsStr, found := strings.Cut(entry, "=")
+		service = strings.TrimSpace(service)
+		if !found || service == "" {
+			return nil, fmt.Errorf("entry '%s' must have the form service=tag|tag", entry)
+		}
+		if _, exists := clientTags[service]; exists {
+			return nil, fmt.Errorf("service '%s' is specified more than once", service)
+		}
+
+		var tags []string
+		for tag := range strings.SplitSeq(tagsStr, "|") {
+			tag = strings.TrimSpace(tag)
+			if tag == "" {
+				continue
+			}
+			if !slices.Contains(knownClientTags, tag) {
+				return nil, fmt.Errorf("unknown client tag '%s' for service '%s'", tag, service)
+			}
+			tags = append(tags, tag)
+		}
+		if len(tags) == 0 {
+			return nil, fmt.Errorf("no client tags specified for service '%s'", service)
+		}
+		clientTags[service] = tags
+	}
+	return clientTags, nil
+}
+
 func (c *Config) IsAllServices() bool {
 	return len(c.ServiceNames) == 1 && c.ServiceNames[0] == "*"
 }
@@ -107,3 +560,51 @@ func (c *Config) HasService(serviceName string) bool {
 	}
 	return slices.Contains(c.ServiceNames, serviceName)
 }
+
+// ClientTagsFor returns the client tags the rules of a service are restricted
+// to, falling back to the "*" entry. A nil result means the rules apply to all clients.
+func (c *Config) ClientTagsFor(serviceName string) []string {
+	if tags, ok := c.ClientTags[serviceName]; ok {
+		return tags
+	}
+	return c.ClientTags["*"]
+}
+
+// RestartRequired lists the settings that differ in next but only take effect
+// on restart, because they configure the AdGuard connection, the HTTP servers
+// or the managed section to write to.
+func (c *Config) RestartRequired(next *Config) []string {
+	var changed []string
+	if c.AdguardAPI.String() != next.AdguardAPI.String() {
+		changed = append(changed, "ADGUARD_API")
+	}
+	if c.Username != next.Username || c.Password != next.Password {
+		changed = append(changed, "ADGUARD_USERNAME/ADGUARD_PASSWORD")
+	}
+	if c.AuthMode != next.AuthMode {
+		changed = append(changed, "ADGUARD_AUTH_MODE")
+	}
+	if c.AdguardTLS != next.AdguardTLS {
+		changed = append(changed, "ADGUARD_TLS_*")
+	}
+	if c.Timeout != next.Timeout {
+		changed = append(changed, "TIMEOUT")
+	}
+	if c.InstanceID != next.InstanceID {
+		// The section of the old instance would be left behind
+		changed = append(changed, "INSTANCE_ID")
+	}
+	if c.SyncMode != next.SyncMode {
+		changed = append(changed, "SYNC_MODE")
+	}
+	if c.FilterList != next.FilterList {
+		changed = append(changed, "FILTER_LIST_*")
+	}
+	if c.MetricsListen != next.MetricsListen {
+		changed = append(changed, "METRICS_LISTEN")
+	}
+	if c.ControlToken != next.ControlToken {
+		changed = append(changed, "CONTROL_TOKEN")
+	}
+	return changed
+}
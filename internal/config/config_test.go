@@ -1,8 +1,13 @@
 package config
 
 import (
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoad(t *testing.T) {
@@ -105,6 +110,256 @@ func TestLoad(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "client tags per service",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME": "admin",
+				"ADGUARD_PASSWORD": "password",
+				"LANCACHE_SERVER":  "192.168.1.100",
+				"ADGUARD_API":      "http://localhost:3000",
+				"SERVICE_NAMES":    "steam,wsus",
+				"CLIENT_TAGS":      "steam=device_pc|device_gameconsole, *=device_pc",
+			},
+			wantErr: false,
+		},
+		{
+			name: "unknown client tag",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME": "admin",
+				"ADGUARD_PASSWORD": "password",
+				"LANCACHE_SERVER":  "192.168.1.100",
+				"ADGUARD_API":      "http://localhost:3000",
+				"SERVICE_NAMES":    "steam",
+				"CLIENT_TAGS":      "steam=device_toaster",
+			},
+			wantErr: true,
+		},
+		{
+			name: "client tags without service",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME": "admin",
+				"ADGUARD_PASSWORD": "password",
+				"LANCACHE_SERVER":  "192.168.1.100",
+				"ADGUARD_API":      "http://localhost:3000",
+				"SERVICE_NAMES":    "steam",
+				"CLIENT_TAGS":      "device_pc",
+			},
+			wantErr: true,
+		},
+		{
+			name: "tls options",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME":                 "admin",
+				"ADGUARD_PASSWORD":                 "password",
+				"LANCACHE_SERVER":                  "192.168.1.100",
+				"ADGUARD_API":                      "https://adguard.internal",
+				"SERVICE_NAMES":                    "steam",
+				"ADGUARD_TLS_CA_FILE":              "/certs/ca.pem",
+				"ADGUARD_TLS_CERT_FILE":            "/certs/client.pem",
+				"ADGUARD_TLS_KEY_FILE":             "/certs/client-key.pem",
+				"ADGUARD_TLS_SERVER_NAME":          "adguard.internal",
+				"ADGUARD_TLS_INSECURE_SKIP_VERIFY": "false",
+			},
+			wantErr: false,
+		},
+		{
+			name: "tls client certificate without key",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME":      "admin",
+				"ADGUARD_PASSWORD":      "password",
+				"LANCACHE_SERVER":       "192.168.1.100",
+				"ADGUARD_API":           "https://adguard.internal",
+				"SERVICE_NAMES":         "steam",
+				"ADGUARD_TLS_CERT_FILE": "/certs/client.pem",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid tls insecure skip verify",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME":                 "admin",
+				"ADGUARD_PASSWORD":                 "password",
+				"LANCACHE_SERVER":                  "192.168.1.100",
+				"ADGUARD_API":                      "https://adguard.internal",
+				"SERVICE_NAMES":                    "steam",
+				"ADGUARD_TLS_INSECURE_SKIP_VERIFY": "maybe",
+			},
+			wantErr: true,
+		},
+		{
+			name: "session auth mode",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME":  "admin",
+				"ADGUARD_PASSWORD":  "password",
+				"LANCACHE_SERVER":   "192.168.1.100",
+				"ADGUARD_API":       "http://localhost:3000",
+				"SERVICE_NAMES":     "steam",
+				"ADGUARD_AUTH_MODE": "session",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid auth mode",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME":  "admin",
+				"ADGUARD_PASSWORD":  "password",
+				"LANCACHE_SERVER":   "192.168.1.100",
+				"ADGUARD_API":       "http://localhost:3000",
+				"SERVICE_NAMES":     "steam",
+				"ADGUARD_AUTH_MODE": "token",
+			},
+			wantErr: true,
+		},
+		{
+			name: "filter list mode",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME": "admin",
+				"ADGUARD_PASSWORD": "password",
+				"LANCACHE_SERVER":  "192.168.1.100",
+				"ADGUARD_API":      "http://localhost:3000",
+				"SERVICE_NAMES":    "steam",
+				"SYNC_MODE":        "filter_list",
+				"FILTER_LIST_URL":  "http://lancache-dns-sync:8080/filter.txt",
+			},
+			wantErr: false,
+		},
+		{
+			name: "filter list mode without url",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME": "admin",
+				"ADGUARD_PASSWORD": "password",
+				"LANCACHE_SERVER":  "192.168.1.100",
+				"ADGUARD_API":      "http://localhost:3000",
+				"SERVICE_NAMES":    "steam",
+				"SYNC_MODE":        "filter_list",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid sync mode",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME": "admin",
+				"ADGUARD_PASSWORD": "password",
+				"LANCACHE_SERVER":  "192.168.1.100",
+				"ADGUARD_API":      "http://localhost:3000",
+				"SERVICE_NAMES":    "steam",
+				"SYNC_MODE":        "rewrites",
+			},
+			wantErr: true,
+		},
+		{
+			name: "state dir with backup retention",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME": "admin",
+				"ADGUARD_PASSWORD": "password",
+				"LANCACHE_SERVER":  "192.168.1.100",
+				"ADGUARD_API":      "http://localhost:3000",
+				"SERVICE_NAMES":    "steam",
+				"STATE_DIR":        "/data",
+				"BACKUP_RETENTION": "5",
+				"BACKUP_MAX_AGE":   "720h",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid backup retention",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME": "admin",
+				"ADGUARD_PASSWORD": "password",
+				"LANCACHE_SERVER":  "192.168.1.100",
+				"ADGUARD_API":      "http://localhost:3000",
+				"SERVICE_NAMES":    "steam",
+				"BACKUP_RETENTION": "0",
+			},
+			wantErr: true,
+		},
+		{
+			name: "removal limits",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME":    "admin",
+				"ADGUARD_PASSWORD":    "password",
+				"LANCACHE_SERVER":     "192.168.1.100",
+				"ADGUARD_API":         "http://localhost:3000",
+				"SERVICE_NAMES":       "steam",
+				"MAX_REMOVAL_PERCENT": "25.5",
+				"MAX_REMOVAL_COUNT":   "1000",
+				"ALLOW_MASS_REMOVAL":  "true",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid removal percent",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME":    "admin",
+				"ADGUARD_PASSWORD":    "password",
+				"LANCACHE_SERVER":     "192.168.1.100",
+				"ADGUARD_API":         "http://localhost:3000",
+				"SERVICE_NAMES":       "steam",
+				"MAX_REMOVAL_PERCENT": "150",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid anomaly shrink percent",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME":       "admin",
+				"ADGUARD_PASSWORD":       "password",
+				"LANCACHE_SERVER":        "192.168.1.100",
+				"ADGUARD_API":            "http://localhost:3000",
+				"SERVICE_NAMES":          "steam",
+				"ANOMALY_SHRINK_PERCENT": "-5",
+			},
+			wantErr: true,
+		},
+		{
+			name: "instance id",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME": "admin",
+				"ADGUARD_PASSWORD": "password",
+				"LANCACHE_SERVER":  "192.168.1.100",
+				"ADGUARD_API":      "http://localhost:3000",
+				"SERVICE_NAMES":    "steam",
+				"INSTANCE_ID":      "lan-party_2",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid instance id",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME": "admin",
+				"ADGUARD_PASSWORD": "password",
+				"LANCACHE_SERVER":  "192.168.1.100",
+				"ADGUARD_API":      "http://localhost:3000",
+				"SERVICE_NAMES":    "steam",
+				"INSTANCE_ID":      "lan party",
+			},
+			wantErr: true,
+		},
+		{
+			name: "rule templates",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME":       "admin",
+				"ADGUARD_PASSWORD":       "password",
+				"LANCACHE_SERVER":        "192.168.1.100",
+				"ADGUARD_API":            "http://localhost:3000",
+				"SERVICE_NAMES":          "steam",
+				"RULE_TEMPLATE_EXACT":    "|{{.Domain}}^$dnsrewrite=NOERROR;{{.RecordType}};{{.Answer}},important",
+				"RULE_TEMPLATE_WILDCARD": "||{{.Domain}}^$dnsrewrite=NOERROR;{{.RecordType}};{{.Answer}},important",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid rule template",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME":    "admin",
+				"ADGUARD_PASSWORD":    "password",
+				"LANCACHE_SERVER":     "192.168.1.100",
+				"ADGUARD_API":         "http://localhost:3000",
+				"SERVICE_NAMES":       "steam",
+				"RULE_TEMPLATE_EXACT": "|{{.Hostname}}^$dnsrewrite={{.Answer}}",
+			},
+			wantErr: true,
+		},
 		{
 			name: "custom sync interval",
 			envVars: map[string]string{
@@ -117,6 +372,132 @@ func TestLoad(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "cron schedule with time zone",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME": "admin",
+				"ADGUARD_PASSWORD": "password",
+				"LANCACHE_SERVER":  "192.168.1.100",
+				"ADGUARD_API":      "http://localhost:3000",
+				"SERVICE_NAMES":    "steam",
+				"SYNC_SCHEDULE":    "0 4 * * *",
+				"SYNC_TIMEZONE":    "Europe/Berlin",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid sync schedule",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME": "admin",
+				"ADGUARD_PASSWORD": "password",
+				"LANCACHE_SERVER":  "192.168.1.100",
+				"ADGUARD_API":      "http://localhost:3000",
+				"SERVICE_NAMES":    "steam",
+				"SYNC_SCHEDULE":    "0 25 * * *",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid sync time zone",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME": "admin",
+				"ADGUARD_PASSWORD": "password",
+				"LANCACHE_SERVER":  "192.168.1.100",
+				"ADGUARD_API":      "http://localhost:3000",
+				"SERVICE_NAMES":    "steam",
+				"SYNC_SCHEDULE":    "0 4 * * *",
+				"SYNC_TIMEZONE":    "Mars/Olympus_Mons",
+			},
+			wantErr: true,
+		},
+		{
+			name: "sync schedule and interval",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME": "admin",
+				"ADGUARD_PASSWORD": "password",
+				"LANCACHE_SERVER":  "192.168.1.100",
+				"ADGUARD_API":      "http://localhost:3000",
+				"SERVICE_NAMES":    "steam",
+				"SYNC_SCHEDULE":    "0 4 * * *",
+				"SYNC_INTERVAL":    "2h",
+			},
+			wantErr: true,
+		},
+		{
+			name: "event windows",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME": "admin",
+				"ADGUARD_PASSWORD": "password",
+				"LANCACHE_SERVER":  "192.168.1.100",
+				"ADGUARD_API":      "http://localhost:3000",
+				"SERVICE_NAMES":    "steam",
+				"EVENT_WINDOWS":    "2025-06-13T18:00/2025-06-15T22:00,fri 18:00-sun 22:00",
+				"SYNC_TIMEZONE":    "Europe/Berlin",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid event windows",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME": "admin",
+				"ADGUARD_PASSWORD": "password",
+				"LANCACHE_SERVER":  "192.168.1.100",
+				"ADGUARD_API":      "http://localhost:3000",
+				"SERVICE_NAMES":    "steam",
+				"EVENT_WINDOWS":    "every weekend",
+			},
+			wantErr: true,
+		},
+		{
+			name: "jitter and retry backoff",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME": "admin",
+				"ADGUARD_PASSWORD": "password",
+				"LANCACHE_SERVER":  "192.168.1.100",
+				"ADGUARD_API":      "http://localhost:3000",
+				"SERVICE_NAMES":    "steam",
+				"SYNC_JITTER":      "10m",
+				"SYNC_RETRY_MIN":   "30s",
+				"SYNC_RETRY_MAX":   "2h",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid timeout",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME": "admin",
+				"ADGUARD_PASSWORD": "password",
+				"LANCACHE_SERVER":  "192.168.1.100",
+				"ADGUARD_API":      "http://localhost:3000",
+				"SERVICE_NAMES":    "steam",
+				"TIMEOUT":          "0s",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid jitter",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME": "admin",
+				"ADGUARD_PASSWORD": "password",
+				"LANCACHE_SERVER":  "192.168.1.100",
+				"ADGUARD_API":      "http://localhost:3000",
+				"SERVICE_NAMES":    "steam",
+				"SYNC_JITTER":      "-5m",
+			},
+			wantErr: true,
+		},
+		{
+			name: "retry min greater than max",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME": "admin",
+				"ADGUARD_PASSWORD": "password",
+				"LANCACHE_SERVER":  "192.168.1.100",
+				"ADGUARD_API":      "http://localhost:3000",
+				"SERVICE_NAMES":    "steam",
+				"SYNC_RETRY_MIN":   "2h",
+			},
+			wantErr: true,
+		},
 		{
 			name: "invalid sync interval",
 			envVars: map[string]string{
@@ -154,6 +535,9 @@ func TestLoad(t *testing.T) {
 				if config.SyncInterval <= 0 {
 					t.Error("Expected sync interval to be set")
 				}
+				if config.Schedule == nil {
+					t.Error("Expected schedule to be set")
+				}
 			}
 		})
 	}
@@ -208,3 +592,252 @@ func TestConfigHasService(t *testing.T) {
 		t.Error("Expected wildcard config to match any service")
 	}
 }
+
+func TestConfigClientTagsFor(t *testing.T) {
+	clientTags, err := parseClientTags("steam=device_pc|device_gameconsole,*=device_pc")
+	if err != nil {
+		t.Fatalf("parseClientTags() error = %v", err)
+	}
+	config := &Config{ClientTags: clientTags}
+
+	tests := []struct {
+		serviceName string
+		expected    string
+	}{
+		{"steam", "device_pc|device_gameconsole"},
+		{"wsus", "device_pc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.serviceName, func(t *testing.T) {
+			if got := strings.Join(config.ClientTagsFor(tt.serviceName), "|"); got != tt.expected {
+				t.Errorf("ClientTagsFor(%s) = %s, want %s", tt.serviceName, got, tt.expected)
+			}
+		})
+	}
+
+	// Test without any client tags
+	emptyConfig := &Config{}
+	if tags := emptyConfig.ClientTagsFor("steam"); tags != nil {
+		t.Errorf("Expected no client tags, got %v", tags)
+	}
+}
+
+func TestLoadSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	usernameFile := filepath.Join(dir, "username")
+	passwordFile := filepath.Join(dir, "password")
+	if err := os.WriteFile(usernameFile, []byte("admin\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write username file: %v", err)
+	}
+	if err := os.WriteFile(passwordFile, []byte("s3cret \r\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write password file: %v", err)
+	}
+
+	baseEnv := map[string]string{
+		"LANCACHE_SERVER": "192.168.1.100",
+		"ADGUARD_API":     "http://localhost:3000",
+		"SERVICE_NAMES":   "steam",
+	}
+
+	tests := []struct {
+		name             string
+		envVars          map[string]string
+		wantErr          bool
+		expectedUsername string
+		expectedPassword string
+	}{
+		{
+			name: "credentials from files",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME_FILE": usernameFile,
+				"ADGUARD_PASSWORD_FILE": passwordFile,
+			},
+			expectedUsername: "admin",
+			expectedPassword: "s3cret ",
+		},
+		{
+			name: "mixed file and environment",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME":      "admin",
+				"ADGUARD_PASSWORD_FILE": passwordFile,
+			},
+			expectedUsername: "admin",
+			expectedPassword: "s3cret ",
+		},
+		{
+			name: "both forms set",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME":      "admin",
+				"ADGUARD_PASSWORD":      "password",
+				"ADGUARD_PASSWORD_FILE": passwordFile,
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing file",
+			envVars: map[string]string{
+				"ADGUARD_USERNAME":      "admin",
+				"ADGUARD_PASSWORD_FILE": filepath.Join(dir, "nonexistent"),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Clearenv()
+
+			for k, v := range baseEnv {
+				t.Setenv(k, v)
+			}
+			for k, v := range tt.envVars {
+				t.Setenv(k, v)
+			}
+
+			config, err := Load()
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr {
+				if config.Username != tt.expectedUsername {
+					t.Errorf("Username = %q, want %q", config.Username, tt.expectedUsername)
+				}
+				if config.Password != tt.expectedPassword {
+					t.Errorf("Password = %q, want %q", config.Password, tt.expectedPassword)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("Failed to write config file: %v", err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name             string
+		content          string
+		wantErr          bool
+		expectedServices []string
+		expectedInterval time.Duration
+	}{
+		{
+			name: "file overrides environment",
+			content: `# lancache-dns-sync
+SERVICE_NAMES=steam,wsus
+
+export SYNC_INTERVAL="2h"
+`,
+			expectedServices: []string{"steam", "wsus"},
+			expectedInterval: 2 * time.Hour,
+		},
+		{
+			name:             "environment as fallback",
+			content:          "SYNC_INTERVAL='30m'\n",
+			expectedServices: []string{"blizzard"},
+			expectedInterval: 30 * time.Minute,
+		},
+		{
+			name:    "invalid line",
+			content: "SERVICE_NAMES=steam\nnot a setting\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid value",
+			content: "SYNC_INTERVAL=often\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Clearenv()
+			t.Setenv("ADGUARD_USERNAME", "admin")
+			t.Setenv("ADGUARD_PASSWORD", "password")
+			t.Setenv("LANCACHE_SERVER", "192.168.1.100")
+			t.Setenv("ADGUARD_API", "http://localhost:3000")
+			t.Setenv("SERVICE_NAMES", "blizzard")
+			t.Setenv("CONFIG_FILE", writeFile("config.env", tt.content))
+
+			config, err := Load()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !slices.Equal(config.ServiceNames, tt.expectedServices) {
+				t.Errorf("ServiceNames = %v, want %v", config.ServiceNames, tt.expectedServices)
+			}
+			if config.SyncInterval != tt.expectedInterval {
+				t.Errorf("SyncInterval = %v, want %v", config.SyncInterval, tt.expectedInterval)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		os.Clearenv()
+		t.Setenv("CONFIG_FILE", filepath.Join(dir, "nonexistent"))
+		if _, err := Load(); err == nil {
+			t.Error("Expected error but got none")
+		}
+	})
+}
+
+func TestConfigRestartRequired(t *testing.T) {
+	base := func() *Config {
+		return &Config{
+			Username:     "admin",
+			Password:     "password",
+			AdguardAPI:   &url.URL{Scheme: "http", Host: "localhost:3000"},
+			AuthMode:     AuthModeBasic,
+			SyncMode:     SyncModeUserRules,
+			ServiceNames: []string{"steam"},
+			FilterList:   FilterListConfig{Listen: DefaultFilterListListen, Name: DefaultFilterListName},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		modify   func(*Config)
+		expected []string
+	}{
+		{"unchanged", func(*Config) {}, nil},
+		{"reloadable settings", func(c *Config) {
+			c.ServiceNames = []string{"steam", "wsus"}
+			c.SyncJitter = time.Minute
+		}, nil},
+		{"api", func(c *Config) { c.AdguardAPI = &url.URL{Scheme: "https", Host: "adguard.lan"} }, []string{"ADGUARD_API"}},
+		{"credentials", func(c *Config) { c.Password = "s3cret" }, []string{"ADGUARD_USERNAME/ADGUARD_PASSWORD"}},
+		{"tls and mode", func(c *Config) {
+			c.AdguardTLS.InsecureSkipVerify = true
+			c.SyncMode = SyncModeFilterList
+		}, []string{"ADGUARD_TLS_*", "SYNC_MODE"}},
+		{"timeout and instance", func(c *Config) {
+			c.Timeout = time.Minute
+			c.InstanceID = "lanparty"
+		}, []string{"TIMEOUT", "INSTANCE_ID"}},
+		{"filter list", func(c *Config) { c.FilterList.Listen = ":9090" }, []string{"FILTER_LIST_*"}},
+		{"metrics", func(c *Config) { c.MetricsListen = ":9100" }, []string{"METRICS_LISTEN"}},
+		{"control token", func(c *Config) { c.ControlToken = "s3cret" }, []string{"CONTROL_TOKEN"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := base()
+			tt.modify(next)
+			if got := base().RestartRequired(next); !slices.Equal(got, tt.expected) {
+				t.Errorf("RestartRequired() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
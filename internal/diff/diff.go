@@ -0,0 +1,201 @@
+// Package diff computes line based diffs in the unified format.
+//
+// It uses the patience algorithm: lines occurring exactly once on both sides
+// anchor the diff, which keeps it fast for the large and mostly unique rule
+// lists AdGuard works with.
+package diff
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type Kind int
+
+const (
+	Equal Kind = iota
+	Delete
+	Insert
+)
+
+// Edit is a single line of the edit script turning a into b.
+type Edit struct {
+	Kind Kind
+	Line string
+}
+
+// Lines returns the edit script turning a into b.
+func Lines(a, b []string) []Edit {
+	d := &differ{a: a, b: b}
+	d.diff(0, len(a), 0, len(b))
+	return d.edits
+}
+
+type differ struct {
+	a, b  []string
+	edits []Edit
+}
+
+func (d *differ) diff(a0, a1, b0, b1 int) {
+	for a0 < a1 && b0 < b1 && d.a[a0] == d.b[b0] {
+		d.edits = append(d.edits, Edit{Equal, d.a[a0]})
+		a0++
+		b0++
+	}
+
+	suffix := 0
+	for a0 < a1-suffix && b0 < b1-suffix && d.a[a1-suffix-1] == d.b[b1-suffix-1] {
+		suffix++
+	}
+	a1 -= suffix
+	b1 -= suffix
+
+	anchors := uniqueAnchors(d.a[a0:a1], d.b[b0:b1])
+	if len(anchors) == 0 {
+		for _, line := range d.a[a0:a1] {
+			d.edits = append(d.edits, Edit{Delete, line})
+		}
+		for _, line := range d.b[b0:b1] {
+			d.edits = append(d.edits, Edit{Insert, line})
+		}
+	} else {
+		pa, pb := a0, b0
+		for _, anchor := range anchors {
+			d.diff(pa, a0+anchor[0], pb, b0+anchor[1])
+			d.edits = append(d.edits, Edit{Equal, d.a[a0+anchor[0]]})
+			pa, pb = a0+anchor[0]+1, b0+anchor[1]+1
+		}
+		d.diff(pa, a1, pb, b1)
+	}
+
+	for _, line := range d.a[a1 : a1+suffix] {
+		d.edits = append(d.edits, Edit{Equal, line})
+	}
+}
+
+// uniqueAnchors returns index pairs of lines occurring exactly once in both
+// a and b, reduced to the longest sequence that is increasing on both sides.
+func uniqueAnchors(a, b []string) [][2]int {
+	type occurrence struct {
+		countA, countB int
+		indexA, indexB int
+	}
+	lines := make(map[string]*occurrence)
+	for i, line := range a {
+		o, ok := lines[line]
+		if !ok {
+			o = &occurrence{}
+			lines[line] = o
+		}
+		o.countA++
+		o.indexA = i
+	}
+	for i, line := range b {
+		if o, ok := lines[line]; ok {
+			o.countB++
+			o.indexB = i
+		}
+	}
+
+	var pairs [][2]int
+	for _, line := range a {
+		if o := lines[line]; o.countA == 1 && o.countB == 1 {
+			pairs = append(pairs, [2]int{o.indexA, o.indexB})
+		}
+	}
+
+	// Longest increasing subsequence on the b index via patience sorting
+	var piles []int
+	prev := make([]int, len(pairs))
+	for i, pair := range pairs {
+		n := sort.Search(len(piles), func(p int) bool { return pairs[piles[p]][1] > pair[1] })
+		if n > 0 {
+			prev[i] = piles[n-1]
+		} else {
+			prev[i] = -1
+		}
+		if n == len(piles) {
+			piles = append(piles, i)
+		} else {
+			piles[n] = i
+		}
+	}
+
+	if len(piles) == 0 {
+		return nil
+	}
+	anchors := make([][2]int, len(piles))
+	for i, k := len(piles)-1, piles[len(piles)-1]; i >= 0; i, k = i-1, prev[k] {
+		anchors[i] = pairs[k]
+	}
+	return anchors
+}
+
+// Unified returns the diff between a and b in the unified format with the
+// given number of context lines, or an empty string if they are equal.
+func Unified(fromName, toName string, a, b []string, context int) string {
+	edits := Lines(a, b)
+
+	var changes []int
+	for i, edit := range edits {
+		if edit.Kind != Equal {
+			changes = append(changes, i)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	// Line numbers before each edit
+	posA := make([]int, len(edits)+1)
+	posB := make([]int, len(edits)+1)
+	for i, edit := range edits {
+		posA[i+1], posB[i+1] = posA[i], posB[i]
+		if edit.Kind != Insert {
+			posA[i+1]++
+		}
+		if edit.Kind != Delete {
+			posB[i+1]++
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	for i := 0; i < len(changes); {
+		// Merge changes whose context overlaps into one hunk
+		j := i
+		for j+1 < len(changes) && changes[j+1]-changes[j] <= 2*context+1 {
+			j++
+		}
+		start := max(changes[i]-context, 0)
+		end := min(changes[j]+context+1, len(edits))
+
+		countA, countB := posA[end]-posA[start], posB[end]-posB[start]
+		startA, startB := posA[start], posB[start]
+		if countA > 0 {
+			startA++
+		}
+		if countB > 0 {
+			startB++
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", startA, countA, startB, countB)
+
+		for _, edit := range edits[start:end] {
+			switch edit.Kind {
+			case Equal:
+				out.WriteString(" ")
+			case Delete:
+				out.WriteString("-")
+			case Insert:
+				out.WriteString("+")
+			}
+			out.WriteString(edit.Line)
+			out.WriteString("\n")
+		}
+		i = j + 1
+	}
+
+	return out.String()
+}
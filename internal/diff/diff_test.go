@@ -0,0 +1,101 @@
+package diff
+
+import (
+	"fmt"
+	"testing"
+)
+
+func apply(edits []Edit) (a, b []string) {
+	a, b = []string{}, []string{}
+	for _, edit := range edits {
+		if edit.Kind != Insert {
+			a = append(a, edit.Line)
+		}
+		if edit.Kind != Delete {
+			b = append(b, edit.Line)
+		}
+	}
+	return a, b
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name        string
+		a, b        []string
+		expectEqual int
+	}{
+		{"equal", []string{"a", "b", "c"}, []string{"a", "b", "c"}, 3},
+		{"empty", []string{}, []string{}, 0},
+		{"only inserts", []string{}, []string{"a", "b"}, 0},
+		{"only deletes", []string{"a", "b"}, []string{}, 0},
+		{"insert in middle", []string{"a", "c"}, []string{"a", "b", "c"}, 2},
+		{"moved line", []string{"a", "b", "c", "d"}, []string{"a", "c", "d", "b"}, 3},
+		{"duplicates", []string{"x", "a", "x", "b"}, []string{"x", "b", "x", "a"}, 2},
+		{"replace", []string{"a", "b", "c"}, []string{"a", "B", "c"}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edits := Lines(tt.a, tt.b)
+
+			a, b := apply(edits)
+			if fmt.Sprint(a) != fmt.Sprint(tt.a) || fmt.Sprint(b) != fmt.Sprint(tt.b) {
+				t.Errorf("Edit script does not reproduce inputs: got %v and %v", a, b)
+			}
+
+			equal := 0
+			for _, edit := range edits {
+				if edit.Kind == Equal {
+					equal++
+				}
+			}
+			if equal != tt.expectEqual {
+				t.Errorf("Expected %d equal lines, got %d", tt.expectEqual, equal)
+			}
+		})
+	}
+}
+
+func TestUnified(t *testing.T) {
+	a := []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12"}
+	b := []string{"1", "2", "three", "4", "5", "6", "7", "8", "9", "10", "11", "12", "13"}
+
+	expected := `--- current
++++ proposed
+@@ -1,6 +1,6 @@
+ 1
+ 2
+-3
++three
+ 4
+ 5
+ 6
+@@ -10,3 +10,4 @@
+ 10
+ 11
+ 12
++13
+`
+
+	if got := Unified("current", "proposed", a, b, 3); got != expected {
+		t.Errorf("Unified() =\n%s\nwant\n%s", got, expected)
+	}
+}
+
+func TestUnifiedEqual(t *testing.T) {
+	if got := Unified("a", "b", []string{"x"}, []string{"x"}, 3); got != "" {
+		t.Errorf("Expected empty diff, got %q", got)
+	}
+}
+
+func TestUnifiedFromEmpty(t *testing.T) {
+	expected := `--- a
++++ b
+@@ -0,0 +1,2 @@
++x
++y
+`
+	if got := Unified("a", "b", nil, []string{"x", "y"}, 3); got != expected {
+		t.Errorf("Unified() =\n%s\nwant\n%s", got, expected)
+	}
+}
@@ -5,10 +5,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/skaronator/lancache-dns-sync/internal/config"
 	"github.com/skaronator/lancache-dns-sync/internal/types"
@@ -18,11 +20,14 @@ const (
 	BaseURL        = "https://raw.githubusercontent.com/uklans/cache-domains/master/"
 	JSONPath       = "cache_domains.json"
 	MaxConcurrency = 10
+	// CommitURL returns the SHA of the upstream commit BaseURL points to.
+	CommitURL = "https://api.github.com/repos/uklans/cache-domains/commits/master"
 )
 
 type Downloader struct {
 	httpClient  *http.Client
 	baseURL     string
+	commitURL   string
 	concurrency int
 }
 
@@ -30,10 +35,42 @@ func NewDownloader(httpClient *http.Client) *Downloader {
 	return &Downloader{
 		httpClient:  httpClient,
 		baseURL:     BaseURL,
+		commitURL:   CommitURL,
 		concurrency: MaxConcurrency,
 	}
 }
 
+// FetchUpstreamCommit returns the SHA of the upstream commit the domain files
+// are downloaded from.
+func (d *Downloader) FetchUpstreamCommit(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", d.commitURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	// Makes GitHub return only the SHA instead of the full commit
+	req.Header.Set("Accept", "application/vnd.github.sha")
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			slog.Error("Failed to close response body", "error", closeErr)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch upstream commit: status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return "", fmt.Errorf("failed to read response body: %w", err)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
 func (d *Downloader) FetchCacheDomains(ctx context.Context) (*types.CacheDomainsResponse, error) {
 	url := d.baseURL + JSONPath
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
@@ -96,15 +133,38 @@ func (d *Downloader) GetServiceFilePaths(domains *types.CacheDomainsResponse, cf
 	return filePaths
 }
 
-func (d *Downloader) downloadDomainFile(ctx context.Context, url string) ([]string, error) {
+// ServicesByFile maps every domain file path to the name of the service that lists it.
+func (d *Downloader) ServicesByFile(domains *types.CacheDomainsResponse) map[string]string {
+	services := make(map[string]string)
+	for _, domain := range domains.CacheDomains {
+		for _, file := range domain.DomainFiles {
+			services[file] = domain.Name
+		}
+	}
+	return services
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (d *Downloader) downloadDomainFile(ctx context.Context, url string) ([]string, int64, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	resp, err := d.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute request: %w", err)
+		return nil, 0, fmt.Errorf("failed to execute request: %w", err)
 	}
 	defer func() {
 		if closeErr := resp.Body.Close(); closeErr != nil {
@@ -113,11 +173,12 @@ func (d *Downloader) downloadDomainFile(ctx context.Context, url string) ([]stri
 	}()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to download %s: status %d", url, resp.StatusCode)
+		return nil, 0, fmt.Errorf("failed to download %s: status %d", url, resp.StatusCode)
 	}
 
 	var domains []string
-	scanner := bufio.NewScanner(resp.Body)
+	body := &countingReader{r: resp.Body}
+	scanner := bufio.NewScanner(body)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 		if line != "" && !strings.HasPrefix(line, "#") {
@@ -126,30 +187,62 @@ func (d *Downloader) downloadDomainFile(ctx context.Context, url string) ([]stri
 	}
 
 	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("failed to scan response body: %w", err)
+		return nil, body.n, fmt.Errorf("failed to scan response body: %w", err)
 	}
 
-	return domains, nil
+	return domains, body.n, nil
+}
+
+// DownloadResult holds the rewrites of downloaded domain files along with
+// statistics about the download.
+type DownloadResult struct {
+	Rewrites []types.DNSRewrite
+	// FailedFiles are the paths of files that could not be downloaded.
+	FailedFiles []string
+	// Bytes is the total size of all downloaded files.
+	Bytes int64
 }
 
 func (d *Downloader) DownloadDomainsFromFiles(ctx context.Context, filePaths []string, lancacheServer string) ([]types.DNSRewrite, error) {
+	result, err := d.DownloadDomainFiles(ctx, filePaths, lancacheServer)
+	if err != nil {
+		return nil, err
+	}
+	return result.Rewrites, nil
+}
+
+// DownloadDomainFiles downloads the domain files concurrently. Files that
+// fail to download are logged and reported in the result. When ctx is
+// canceled, pending downloads are aborted and the error of ctx is returned.
+func (d *Downloader) DownloadDomainFiles(ctx context.Context, filePaths []string, lancacheServer string) (*DownloadResult, error) {
 	var wg sync.WaitGroup
-	var mu sync.Mutex
-	var allRewrites []types.DNSRewrite
+	// Results are kept per file so the rewrites keep the order of filePaths
+	results := make([][]types.DNSRewrite, len(filePaths))
+	failed := make([]bool, len(filePaths))
+	var bytes atomic.Int64
 
 	semaphore := make(chan struct{}, d.concurrency)
 
-	for _, filePath := range filePaths {
+	for i, filePath := range filePaths {
 		wg.Add(1)
-		go func(path string) {
+		go func(i int, path string) {
 			defer wg.Done()
-			semaphore <- struct{}{}
+			select {
+			case semaphore <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
 			defer func() { <-semaphore }()
 
 			url := d.baseURL + path
-			domains, err := d.downloadDomainFile(ctx, url)
+			domains, n, err := d.downloadDomainFile(ctx, url)
+			bytes.Add(n)
+			if ctx.Err() != nil {
+				return
+			}
 			if err != nil {
 				slog.Error("Error downloading domain file", "url", url, "error", err)
+				failed[i] = true
 				return
 			}
 
@@ -158,15 +251,25 @@ func (d *Downloader) DownloadDomainsFromFiles(ctx context.Context, filePaths []s
 				rewrites = append(rewrites, types.DNSRewrite{
 					Domain: domain,
 					Answer: lancacheServer,
+					Source: path,
 				})
 			}
 
-			mu.Lock()
-			allRewrites = append(allRewrites, rewrites...)
-			mu.Unlock()
-		}(filePath)
+			results[i] = rewrites
+		}(i, filePath)
 	}
 
 	wg.Wait()
-	return allRewrites, nil
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &DownloadResult{Bytes: bytes.Load()}
+	for i, rewrites := range results {
+		result.Rewrites = append(result.Rewrites, rewrites...)
+		if failed[i] {
+			result.FailedFiles = append(result.FailedFiles, filePaths[i])
+		}
+	}
+	return result, nil
 }
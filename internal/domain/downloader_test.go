@@ -2,6 +2,7 @@ package domain
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -88,6 +89,33 @@ func TestGetServiceFilePaths(t *testing.T) {
 	}
 }
 
+func TestServicesByFile(t *testing.T) {
+	downloader := NewDownloader(&http.Client{Timeout: 30 * time.Second})
+
+	domains := &types.CacheDomainsResponse{
+		CacheDomains: []types.CacheDomain{
+			{Name: "steam", DomainFiles: []string{"steam.txt", "steam_china.txt"}},
+			{Name: "origin", DomainFiles: []string{"origin.txt"}},
+		},
+	}
+
+	services := downloader.ServicesByFile(domains)
+
+	expected := map[string]string{
+		"steam.txt":       "steam",
+		"steam_china.txt": "steam",
+		"origin.txt":      "origin",
+	}
+	if len(services) != len(expected) {
+		t.Errorf("Expected %d files, got %d", len(expected), len(services))
+	}
+	for file, service := range expected {
+		if services[file] != service {
+			t.Errorf("Expected file %s to belong to %s, got %s", file, service, services[file])
+		}
+	}
+}
+
 func TestDownloadDomainFile(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if _, err := w.Write([]byte(`# Steam CDN domains
@@ -103,7 +131,7 @@ steamstatic.com
 	defer server.Close()
 
 	downloader := NewDownloader(&http.Client{Timeout: 30 * time.Second})
-	domains, err := downloader.downloadDomainFile(context.Background(), server.URL)
+	domains, _, err := downloader.downloadDomainFile(context.Background(), server.URL)
 
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
@@ -336,6 +364,15 @@ ea.com`)); err != nil {
 					t.Errorf("Expected domain %s not found in results", expectedDomain)
 				}
 			}
+
+			// Rewrites keep the order of the files and of the domains within them
+			if len(tt.expectedDomains) == len(rewrites) {
+				for i, expectedDomain := range tt.expectedDomains {
+					if rewrites[i].Domain != expectedDomain {
+						t.Errorf("Expected domain %s at index %d, got %s", expectedDomain, i, rewrites[i].Domain)
+					}
+				}
+			}
 		})
 	}
 }
@@ -369,7 +406,7 @@ func TestDownloadDomainFileErrors(t *testing.T) {
 
 			downloader := NewDownloader(&http.Client{Timeout: 1 * time.Second})
 
-			_, err := downloader.downloadDomainFile(context.Background(), server.URL)
+			_, _, err := downloader.downloadDomainFile(context.Background(), server.URL)
 
 			if tt.expectError && err == nil {
 				t.Error("Expected error but got none")
@@ -397,7 +434,7 @@ steamstatic.com
 	defer server.Close()
 
 	downloader := NewDownloader(&http.Client{Timeout: 30 * time.Second})
-	domains, err := downloader.downloadDomainFile(context.Background(), server.URL)
+	domains, _, err := downloader.downloadDomainFile(context.Background(), server.URL)
 
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
@@ -415,3 +452,119 @@ steamstatic.com
 		}
 	}
 }
+
+func TestDownloadDomainFilesStats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/steam.txt" {
+			w.WriteHeader(404)
+			return
+		}
+		if _, err := w.Write([]byte("steampowered.com\nsteamcontent.com\n")); err != nil {
+			t.Errorf("Failed to write response: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	downloader := NewDownloader(&http.Client{Timeout: 30 * time.Second})
+	downloader.baseURL = server.URL + "/"
+
+	result, err := downloader.DownloadDomainFiles(context.Background(), []string{"steam.txt", "missing.txt"}, "192.168.1.100")
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+
+	if len(result.Rewrites) != 2 {
+		t.Errorf("Expected 2 rewrites, got %d", len(result.Rewrites))
+	}
+	if len(result.FailedFiles) != 1 || result.FailedFiles[0] != "missing.txt" {
+		t.Errorf("Expected failed files [missing.txt], got %v", result.FailedFiles)
+	}
+	if result.Bytes != int64(len("steampowered.com\nsteamcontent.com\n")) {
+		t.Errorf("Expected %d bytes, got %d", len("steampowered.com\nsteamcontent.com\n"), result.Bytes)
+	}
+}
+
+func TestDownloadDomainFilesCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The server hangs until the client gives up
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	downloader := NewDownloader(&http.Client{Timeout: 30 * time.Second})
+	downloader.baseURL = server.URL + "/"
+	downloader.concurrency = 1
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := downloader.DownloadDomainFiles(ctx, []string{"steam.txt", "wsus.txt", "blizzard.txt"}, "192.168.1.100")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("DownloadDomainFiles() did not return after cancellation")
+	}
+}
+
+func TestFetchUpstreamCommit(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		body        string
+		expected    string
+		expectError bool
+	}{
+		{
+			name:     "success",
+			status:   http.StatusOK,
+			body:     "0123456789abcdef0123456789abcdef01234567\n",
+			expected: "0123456789abcdef0123456789abcdef01234567",
+		},
+		{
+			name:        "rate limited",
+			status:      http.StatusForbidden,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Accept") != "application/vnd.github.sha" {
+					t.Errorf("Unexpected Accept header %q", r.Header.Get("Accept"))
+				}
+				w.WriteHeader(tt.status)
+				if _, err := w.Write([]byte(tt.body)); err != nil {
+					t.Errorf("Failed to write response: %v", err)
+				}
+			}))
+			defer server.Close()
+
+			downloader := NewDownloader(&http.Client{Timeout: 30 * time.Second})
+			downloader.commitURL = server.URL
+
+			commit, err := downloader.FetchUpstreamCommit(context.Background())
+			if tt.expectError {
+				if err == nil {
+					t.Error("Expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error but got: %v", err)
+			}
+			if commit != tt.expected {
+				t.Errorf("Expected commit %q, got %q", tt.expected, commit)
+			}
+		})
+	}
+}
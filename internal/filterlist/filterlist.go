@@ -0,0 +1,76 @@
+package filterlist
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Path is the path the filter list is served under.
+const Path = "/filter.txt"
+
+// List holds the generated rules and serves them as an AdGuard compatible
+// blocklist.
+type List struct {
+	title string
+
+	mu        sync.RWMutex
+	rules     []string
+	updatedAt time.Time
+}
+
+func New(title string) *List {
+	return &List{title: title}
+}
+
+// SetRules replaces the rules served by the list.
+func (l *List) SetRules(rules []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rules = rules
+	l.updatedAt = time.Now()
+}
+
+// Rules returns the rules currently served by the list.
+func (l *List) Rules() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.rules
+}
+
+func (l *List) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	l.mu.RLock()
+	rules := l.rules
+	updatedAt := l.updatedAt
+	l.mu.RUnlock()
+
+	if updatedAt.IsZero() {
+		// Not synced yet, an empty list would remove all rewrites in AdGuard
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "! Title: %s\n", l.title)
+	fmt.Fprintf(&b, "! Last modified: %s\n", updatedAt.UTC().Format(time.RFC3339))
+	for _, rule := range rules {
+		b.WriteString(rule)
+		b.WriteByte('\n')
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Last-Modified", updatedAt.UTC().Format(http.TimeFormat))
+	if _, err := w.Write([]byte(b.String())); err != nil {
+		slog.Error("Failed to write filter list", "error", err)
+	}
+}
@@ -0,0 +1,50 @@
+package filterlist
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestListServeHTTP(t *testing.T) {
+	list := New("lancache-dns-sync")
+
+	// Not synced yet
+	recorder := httptest.NewRecorder()
+	list.ServeHTTP(recorder, httptest.NewRequest("GET", Path, nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d before first sync, got %d", http.StatusServiceUnavailable, recorder.Code)
+	}
+
+	list.SetRules([]string{
+		"||steamcontent.com^$dnsrewrite=192.168.1.1",
+		"|test.com^$dnsrewrite=192.168.1.1",
+	})
+
+	recorder = httptest.NewRecorder()
+	list.ServeHTTP(recorder, httptest.NewRequest("GET", Path, nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, recorder.Code)
+	}
+
+	lines := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected 4 lines, got %d: %v", len(lines), lines)
+	}
+	if lines[0] != "! Title: lancache-dns-sync" {
+		t.Errorf("Expected title header, got %s", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "! Last modified: ") {
+		t.Errorf("Expected last modified header, got %s", lines[1])
+	}
+	if lines[2] != "||steamcontent.com^$dnsrewrite=192.168.1.1" {
+		t.Errorf("Expected first rule, got %s", lines[2])
+	}
+
+	recorder = httptest.NewRecorder()
+	list.ServeHTTP(recorder, httptest.NewRequest("POST", Path, nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status %d for POST, got %d", http.StatusMethodNotAllowed, recorder.Code)
+	}
+}
@@ -0,0 +1,228 @@
+// Package metrics collects metrics and serves them in the Prometheus text
+// exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds metrics and serves them in the Prometheus text format.
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	// bucketCounts, sum and count are used by histograms
+	bucketCounts []uint64
+	sum          float64
+	count        uint64
+}
+
+func (r *Registry) register(name, help, kind string, buckets []float64, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f := &family{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: make(map[string]*series)}
+	if len(labels) == 0 {
+		// Metrics without labels are reported from the start
+		f.get(nil)
+	}
+	r.families = append(r.families, f)
+	return f
+}
+
+// get returns the series of the label values, creating it if needed. The
+// caller must hold the registry lock.
+func (f *family) get(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: slices.Clone(labelValues)}
+		if f.kind == "histogram" {
+			s.bucketCounts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// Counter is a value that only goes up, per combination of label values.
+type Counter struct {
+	r *Registry
+	f *family
+}
+
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{r: r, f: r.register(name, help, "counter", nil, labels)}
+}
+
+// Inc adds 1 to the counter of the label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the counter of the label values.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	c.r.mu.Lock()
+	defer c.r.mu.Unlock()
+	c.f.get(labelValues).value += v
+}
+
+// Gauge is a value that can go up and down, per combination of label values.
+type Gauge struct {
+	r *Registry
+	f *family
+}
+
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r: r, f: r.register(name, help, "gauge", nil, labels)}
+}
+
+// Set sets the gauge of the label values.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.r.mu.Lock()
+	defer g.r.mu.Unlock()
+	g.f.get(labelValues).value = v
+}
+
+// Reset removes all label values, e.g. before setting the values of services
+// that may have vanished.
+func (g *Gauge) Reset() {
+	g.r.mu.Lock()
+	defer g.r.mu.Unlock()
+	clear(g.f.series)
+	if len(g.f.labels) == 0 {
+		g.f.get(nil)
+	}
+}
+
+// Histogram counts observed values in buckets, per combination of label values.
+type Histogram struct {
+	r *Registry
+	f *family
+}
+
+// Histogram registers a histogram with the upper bounds of its buckets in
+// increasing order. The +Inf bucket is added automatically.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{r: r, f: r.register(name, help, "histogram", buckets, labels)}
+}
+
+// Observe adds v to the histogram of the label values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.r.mu.Lock()
+	defer h.r.mu.Unlock()
+	s := h.f.get(labelValues)
+	for i, bound := range h.f.buckets {
+		if v <= bound {
+			s.bucketCounts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// Write writes all metrics in the Prometheus text format.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var b strings.Builder
+	for _, f := range r.families {
+		fmt.Fprintf(&b, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(&b, "# TYPE %s %s\n", f.name, f.kind)
+
+		for _, key := range slices.Sorted(maps.Keys(f.series)) {
+			s := f.series[key]
+			if f.kind != "histogram" {
+				fmt.Fprintf(&b, "%s%s %s\n", f.name, formatLabels(f.labels, s.labelValues, "", ""), formatValue(s.value))
+				continue
+			}
+			for i, bound := range f.buckets {
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.labelValues, "le", formatValue(bound)), s.bucketCounts[i])
+			}
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.labelValues, "le", "+Inf"), s.count)
+			fmt.Fprintf(&b, "%s_sum%s %s\n", f.name, formatLabels(f.labels, s.labelValues, "", ""), formatValue(s.sum))
+			fmt.Fprintf(&b, "%s_count%s %d\n", f.name, formatLabels(f.labels, s.labelValues, "", ""), s.count)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ServeHTTP serves the metrics for Prometheus to scrape.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := r.Write(w); err != nil {
+		slog.Error("Failed to write metrics", "error", err)
+	}
+}
+
+// formatLabels formats the labels of a sample, with an extra label like the
+// le of histogram buckets if extraName is set.
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escapeLabelValue(values[i])+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	registry := NewRegistry()
+	syncs := registry.Counter("syncs_total", "Syncs run.")
+	failures := registry.Counter("failures_total", "Failed syncs by reason.", "reason")
+	domains := registry.Gauge("domains", "Domains per service.", "service")
+	latency := registry.Histogram("latency_seconds", "Request latency.", []float64{0.1, 1}, "endpoint")
+
+	syncs.Inc()
+	syncs.Add(2)
+	failures.Inc("network")
+	failures.Inc("network")
+	failures.Inc("unauthorized")
+	domains.Set(3, `steam "valve"`)
+	domains.Reset()
+	domains.Set(12, "steam")
+	domains.Set(4, "blizzard")
+	latency.Observe(0.05, "/control/status")
+	latency.Observe(0.5, "/control/status")
+	latency.Observe(2, "/control/status")
+
+	var b strings.Builder
+	if err := registry.Write(&b); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	expected := `# HELP syncs_total Syncs run.
+# TYPE syncs_total counter
+syncs_total 3
+# HELP failures_total Failed syncs by reason.
+# TYPE failures_total counter
+failures_total{reason="network"} 2
+failures_total{reason="unauthorized"} 1
+# HELP domains Domains per service.
+# TYPE domains gauge
+domains{service="blizzard"} 4
+domains{service="steam"} 12
+# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{endpoint="/control/status",le="0.1"} 1
+latency_seconds_bucket{endpoint="/control/status",le="1"} 2
+latency_seconds_bucket{endpoint="/control/status",le="+Inf"} 3
+latency_seconds_sum{endpoint="/control/status"} 2.55
+latency_seconds_count{endpoint="/control/status"} 3
+`
+	if b.String() != expected {
+		t.Errorf("Write() =\n%s\nwant\n%s", b.String(), expected)
+	}
+}
+
+func TestRegistryEscaping(t *testing.T) {
+	registry := NewRegistry()
+	registry.Gauge("info", "Help with \\ and\nnewline.", "value").Set(1, "a \"quoted\" \\ value\n")
+
+	var b strings.Builder
+	if err := registry.Write(&b); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	expected := `# HELP info Help with \\ and\nnewline.
+# TYPE info gauge
+info{value="a \"quoted\" \\ value\n"} 1
+`
+	if b.String() != expected {
+		t.Errorf("Write() =\n%s\nwant\n%s", b.String(), expected)
+	}
+}
+
+func TestRegistryServeHTTP(t *testing.T) {
+	registry := NewRegistry()
+	registry.Gauge("up", "Whether the service is up.").Set(1)
+
+	rec := httptest.NewRecorder()
+	registry.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if contentType := rec.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("Expected the Prometheus text format, got Content-Type %q", contentType)
+	}
+	if !strings.Contains(rec.Body.String(), "\nup 1\n") {
+		t.Errorf("Expected the gauge in the response, got %q", rec.Body.String())
+	}
+}
+
+func TestLabelCountMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic for a missing label value")
+		}
+	}()
+	NewRegistry().Counter("failures_total", "Failed syncs by reason.", "reason").Inc()
+}
@@ -0,0 +1,153 @@
+// Package ruletemplate renders the AdGuard rules of DNS rewrites from
+// text/template templates.
+package ruletemplate
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+const (
+	// DefaultExact matches only the domain itself.
+	DefaultExact = "|{{.Domain}}^$dnsrewrite={{.Answer}}{{with .ClientTags}},ctag={{.}}{{end}}"
+	// DefaultWildcard matches the domain and all its subdomains.
+	DefaultWildcard = "||{{.Domain}}^$dnsrewrite={{.Answer}}{{with .ClientTags}},ctag={{.}}{{end}}"
+)
+
+// Data is passed to the templates.
+type Data struct {
+	// Domain is the domain to rewrite, without the "*." of wildcards.
+	Domain string
+	// Answer is the IP address of the Lancache server.
+	Answer  string
+	Service string
+	// RecordType is A for IPv4 and AAAA for IPv6 answers.
+	RecordType string
+	// ClientTags are the client tags the rule is restricted to, separated by
+	// "|" as in the $ctag modifier. Empty means all clients.
+	ClientTags string
+}
+
+// Template renders a rule.
+type Template struct {
+	tmpl *template.Template
+	// clientTags is true if the template places the client tags itself
+	clientTags bool
+}
+
+// Parse parses text and checks that it renders a valid rule.
+func Parse(name, text string) (*Template, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	t := &Template{tmpl: tmpl}
+	for _, defined := range tmpl.Templates() {
+		if defined.Tree != nil && usesField(defined.Root, "ClientTags") {
+			t.clientTags = true
+		}
+	}
+
+	// Catches references to unknown fields, which only fail when executed
+	if _, err := t.Execute(Data{Domain: "example.com", Answer: "192.168.1.1", Service: "steam", RecordType: "A", ClientTags: "device_pc"}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// MustParse is like Parse but panics on errors.
+func MustParse(name, text string) *Template {
+	t, err := Parse(name, text)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Execute renders the rule for data. Templates not using ClientTags get the
+// $ctag modifier appended.
+func (t *Template) Execute(data Data) (string, error) {
+	var b strings.Builder
+	if err := t.tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	rule := strings.TrimSpace(b.String())
+	if data.ClientTags != "" && !t.clientTags && rule != "" {
+		separator := ","
+		if !strings.Contains(rule, "$") {
+			separator = "$"
+		}
+		rule += separator + "ctag=" + data.ClientTags
+	}
+	switch {
+	case rule == "":
+		return "", errors.New("template renders an empty rule")
+	case strings.ContainsAny(rule, "\r\n"):
+		return "", fmt.Errorf("template renders more than one line: %q", rule)
+	case strings.HasPrefix(rule, "#") || strings.HasPrefix(rule, "!"):
+		return "", fmt.Errorf("template renders a comment: %q", rule)
+	}
+	return rule, nil
+}
+
+// usesField reports whether the parse tree below node reads the field name,
+// e.g. as {{.ClientTags}}, {{$data.ClientTags}} or {{(.).ClientTags}}.
+// Comments and string literals are not fields.
+func usesField(node parse.Node, name string) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, child := range n.Nodes {
+			if usesField(child, name) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return usesField(n.Pipe, name)
+	case *parse.IfNode:
+		return usesField(n.Pipe, name) || usesField(n.List, name) || usesField(n.ElseList, name)
+	case *parse.RangeNode:
+		return usesField(n.Pipe, name) || usesField(n.List, name) || usesField(n.ElseList, name)
+	case *parse.WithNode:
+		return usesField(n.Pipe, name) || usesField(n.List, name) || usesField(n.ElseList, name)
+	case *parse.TemplateNode:
+		return usesField(n.Pipe, name)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, cmd := range n.Cmds {
+			if usesField(cmd, name) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if usesField(arg, name) {
+				return true
+			}
+		}
+	case *parse.FieldNode:
+		return slices.Contains(n.Ident, name)
+	case *parse.VariableNode:
+		return slices.Contains(n.Ident[1:], name)
+	case *parse.ChainNode:
+		return usesField(n.Node, name) || slices.Contains(n.Field, name)
+	}
+	return false
+}
+
+// RecordType returns the DNS record type of answer.
+func RecordType(answer string) string {
+	if ip := net.ParseIP(answer); ip != nil && ip.To4() == nil {
+		return "AAAA"
+	}
+	return "A"
+}
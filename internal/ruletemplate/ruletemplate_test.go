@@ -0,0 +1,141 @@
+package ruletemplate
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name        string
+		text        string
+		expectError bool
+	}{
+		{"default exact", DefaultExact, false},
+		{"default wildcard", DefaultWildcard, false},
+		{"important", "|{{.Domain}}^$dnsrewrite=NOERROR;{{.RecordType}};{{.Answer}},important", false},
+		{"syntax error", "|{{.Domain}^", true},
+		{"unknown field", "|{{.Host}}^$dnsrewrite={{.Answer}}", true},
+		{"empty", "{{/* nothing */}}", true},
+		{"comment", "# {{.Domain}}", true},
+		{"multiple lines", "|{{.Domain}}^\n||{{.Domain}}^", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.name, tt.text)
+			if tt.expectError && err == nil {
+				t.Error("Expected error but got none")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Expected no error but got: %v", err)
+			}
+		})
+	}
+}
+
+func TestExecute(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		data     Data
+		expected string
+	}{
+		{
+			name:     "default exact",
+			text:     DefaultExact,
+			data:     Data{Domain: "cdn.blizzard.com", Answer: "192.168.1.1"},
+			expected: "|cdn.blizzard.com^$dnsrewrite=192.168.1.1",
+		},
+		{
+			name:     "default wildcard",
+			text:     DefaultWildcard,
+			data:     Data{Domain: "cdn.blizzard.com", Answer: "192.168.1.1"},
+			expected: "||cdn.blizzard.com^$dnsrewrite=192.168.1.1",
+		},
+		{
+			name:     "default with client tags",
+			text:     DefaultExact,
+			data:     Data{Domain: "cdn.blizzard.com", Answer: "192.168.1.1", ClientTags: "device_pc|device_gameconsole"},
+			expected: "|cdn.blizzard.com^$dnsrewrite=192.168.1.1,ctag=device_pc|device_gameconsole",
+		},
+		{
+			name:     "client tags placed by the template",
+			text:     "|{{.Domain}}^$dnsrewrite={{.Answer}}{{with .ClientTags}},ctag={{.}}{{end}},important",
+			data:     Data{Domain: "cdn.blizzard.com", Answer: "192.168.1.1", ClientTags: "device_pc"},
+			expected: "|cdn.blizzard.com^$dnsrewrite=192.168.1.1,ctag=device_pc,important",
+		},
+		{
+			name:     "client tags appended",
+			text:     "|{{.Domain}}^$dnsrewrite={{.Answer}},important",
+			data:     Data{Domain: "cdn.blizzard.com", Answer: "192.168.1.1", ClientTags: "device_pc"},
+			expected: "|cdn.blizzard.com^$dnsrewrite=192.168.1.1,important,ctag=device_pc",
+		},
+		{
+			name:     "client tags on a rule without modifiers",
+			text:     "||{{.Domain}}^",
+			data:     Data{Domain: "cdn.blizzard.com", ClientTags: "device_pc"},
+			expected: "||cdn.blizzard.com^$ctag=device_pc",
+		},
+		{
+			name:     "client tags mentioned in a comment",
+			text:     "|{{.Domain}}^$dnsrewrite={{.Answer}}{{/* no .ClientTags here */}}",
+			data:     Data{Domain: "cdn.blizzard.com", Answer: "192.168.1.1", ClientTags: "device_pc"},
+			expected: "|cdn.blizzard.com^$dnsrewrite=192.168.1.1,ctag=device_pc",
+		},
+		{
+			name:     "client tags mentioned in a string",
+			text:     "|{{.Domain}}^$dnsrewrite={{.Answer}}{{if eq .Service \".ClientTags\"}},important{{end}}",
+			data:     Data{Domain: "cdn.blizzard.com", Answer: "192.168.1.1", ClientTags: "device_pc"},
+			expected: "|cdn.blizzard.com^$dnsrewrite=192.168.1.1,ctag=device_pc",
+		},
+		{
+			name:     "client tags read through a variable",
+			text:     "{{$rule := .}}|{{.Domain}}^$dnsrewrite={{.Answer}}{{with $rule.ClientTags}},ctag={{.}}{{end}}",
+			data:     Data{Domain: "cdn.blizzard.com", Answer: "192.168.1.1", ClientTags: "device_pc"},
+			expected: "|cdn.blizzard.com^$dnsrewrite=192.168.1.1,ctag=device_pc",
+		},
+		{
+			name:     "client tags placed by a defined template",
+			text:     `{{define "tags"}},ctag={{.ClientTags}}{{end}}|{{.Domain}}^$dnsrewrite={{.Answer}}{{template "tags" .}}`,
+			data:     Data{Domain: "cdn.blizzard.com", Answer: "192.168.1.1", ClientTags: "device_pc"},
+			expected: "|cdn.blizzard.com^$dnsrewrite=192.168.1.1,ctag=device_pc",
+		},
+		{
+			name:     "full dnsrewrite form",
+			text:     "|{{.Domain}}^$dnsrewrite=NOERROR;{{.RecordType}};{{.Answer}},important",
+			data:     Data{Domain: "steampowered.com", Answer: "fd00::1", RecordType: "AAAA"},
+			expected: "|steampowered.com^$dnsrewrite=NOERROR;AAAA;fd00::1,important",
+		},
+		{
+			name:     "service",
+			text:     "|{{.Domain}}^$dnsrewrite={{.Answer}}{{if eq .Service \"wsus\"}},important{{end}}",
+			data:     Data{Domain: "windowsupdate.com", Answer: "192.168.1.1", Service: "wsus"},
+			expected: "|windowsupdate.com^$dnsrewrite=192.168.1.1,important",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := MustParse(tt.name, tt.text).Execute(tt.data)
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if rule != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, rule)
+			}
+		})
+	}
+}
+
+func TestRecordType(t *testing.T) {
+	tests := map[string]string{
+		"192.168.1.1":     "A",
+		"fd00::1":         "AAAA",
+		"::ffff:10.0.0.1": "A",
+	}
+	for answer, expected := range tests {
+		if got := RecordType(answer); got != expected {
+			t.Errorf("RecordType(%q) = %q, want %q", answer, got, expected)
+		}
+	}
+}
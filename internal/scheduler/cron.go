@@ -0,0 +1,226 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron runs syncs at the times matching a standard five field cron
+// expression: minute, hour, day of month, month and day of week.
+type Cron struct {
+	spec   string
+	loc    *time.Location
+	minute field
+	hour   field
+	dom    field
+	month  field
+	dow    field
+}
+
+// field is the set of values matching one cron field.
+type field struct {
+	values uint64
+	// any is true for "*", which matters for the day fields
+	any bool
+}
+
+func (f field) has(v int) bool {
+	return f.values&(1<<uint(v)) != 0
+}
+
+type fieldRange struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteRange = fieldRange{name: "minute", min: 0, max: 59}
+	hourRange   = fieldRange{name: "hour", min: 0, max: 23}
+	domRange    = fieldRange{name: "day of month", min: 1, max: 31}
+	monthRange  = fieldRange{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7 is accepted as Sunday, too
+	dowRange = fieldRange{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a cron expression evaluated in loc. Fields support
+// lists, ranges, steps and month and weekday names, e.g. "*/15 1-5 * * MON-FRI".
+// The macros @hourly, @daily, @weekly, @monthly and @yearly are accepted, too.
+func ParseCron(spec string, loc *time.Location) (*Cron, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	expr := strings.TrimSpace(spec)
+	if macro, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+
+	c := &Cron{spec: strings.TrimSpace(spec), loc: loc}
+	var err error
+	if c.minute, err = parseField(fields[0], minuteRange); err != nil {
+		return nil, err
+	}
+	if c.hour, err = parseField(fields[1], hourRange); err != nil {
+		return nil, err
+	}
+	if c.dom, err = parseField(fields[2], domRange); err != nil {
+		return nil, err
+	}
+	if c.month, err = parseField(fields[3], monthRange); err != nil {
+		return nil, err
+	}
+	if c.dow, err = parseField(fields[4], dowRange); err != nil {
+		return nil, err
+	}
+	if c.dow.has(7) {
+		c.dow.values |= 1
+	}
+	if _, err := c.next(time.Now()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func parseField(s string, r fieldRange) (field, error) {
+	var f field
+	for part := range strings.SplitSeq(s, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n < 1 {
+				return field{}, fmt.Errorf("invalid step '%s' in %s field", stepPart, r.name)
+			}
+			step = n
+		}
+
+		var low, high int
+		switch {
+		case rangePart == "*":
+			low, high = r.min, r.max
+			if !hasStep {
+				f.any = true
+			}
+		case strings.Contains(rangePart, "-"):
+			lowPart, highPart, _ := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = r.parseValue(lowPart); err != nil {
+				return field{}, err
+			}
+			if high, err = r.parseValue(highPart); err != nil {
+				return field{}, err
+			}
+			if low > high {
+				return field{}, fmt.Errorf("invalid range '%s' in %s field", rangePart, r.name)
+			}
+		default:
+			v, err := r.parseValue(rangePart)
+			if err != nil {
+				return field{}, err
+			}
+			low, high = v, v
+			if hasStep {
+				// "5/15" means every 15 starting at 5
+				high = r.max
+			}
+		}
+
+		for v := low; v <= high; v += step {
+			f.values |= 1 << uint(v)
+		}
+	}
+	return f, nil
+}
+
+func (r fieldRange) parseValue(s string) (int, error) {
+	if v, ok := r.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value '%s' in %s field", s, r.name)
+	}
+	if v < r.min || v > r.max {
+		return 0, fmt.Errorf("value %d out of range %d-%d in %s field", v, r.min, r.max, r.name)
+	}
+	return v, nil
+}
+
+// errNoMatch is used for expressions that never match, like "0 0 31 2 *".
+var errNoMatch = errors.New("cron expression never matches")
+
+// Next returns the first matching minute after t, in the location of the
+// schedule. On days where clocks are set forward, runs in the skipped hour are
+// skipped, too.
+func (c *Cron) Next(t time.Time) time.Time {
+	next, err := c.next(t)
+	if err != nil {
+		return time.Time{}
+	}
+	return next
+}
+
+func (c *Cron) next(after time.Time) (time.Time, error) {
+	// Time zone offsets are whole minutes, so truncating the absolute time
+	// also starts a minute in loc
+	t := after.In(c.loc).Truncate(time.Minute).Add(time.Minute)
+
+	// Every schedule matches within a few years, except impossible dates
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case !c.month.has(int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, c.loc)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, c.loc)
+		case !c.hour.has(t.Hour()):
+			// Adding the remaining minutes instead of setting the hour keeps
+			// the time moving forward across DST changes
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		case !c.minute.has(t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t, nil
+		}
+	}
+	return time.Time{}, errNoMatch
+}
+
+// dayMatches follows cron semantics: when both day fields are restricted, a
+// day matching either of them matches.
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom := c.dom.has(t.Day())
+	dow := c.dow.has(int(t.Weekday()))
+	if c.dom.any || c.dow.any {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func (c *Cron) String() string {
+	return fmt.Sprintf("cron %q in %s", c.spec, c.loc)
+}
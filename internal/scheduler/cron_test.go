@@ -0,0 +1,152 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		name        string
+		spec        string
+		expectError bool
+	}{
+		{"daily", "0 4 * * *", false},
+		{"lists ranges and steps", "*/15 1-5,22 * * 1-5", false},
+		{"names", "30 2 * jan-mar MON,fri", false},
+		{"sunday as 7", "0 0 * * 7", false},
+		{"macro", "@daily", false},
+		{"too few fields", "0 4 * *", true},
+		{"out of range", "60 4 * * *", true},
+		{"invalid step", "*/0 * * * *", true},
+		{"inverted range", "0 5-1 * * *", true},
+		{"unknown name", "0 4 * * monday", true},
+		{"never matches", "0 0 31 2 *", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseCron(tt.spec, time.UTC)
+			if (err != nil) != tt.expectError {
+				t.Errorf("ParseCron(%q) error = %v, expectError %v", tt.spec, err, tt.expectError)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("Time zone data not available: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		spec     string
+		loc      *time.Location
+		after    time.Time
+		expected time.Time
+	}{
+		{
+			name:     "later today",
+			spec:     "0 4 * * *",
+			loc:      time.UTC,
+			after:    time.Date(2025, 3, 10, 1, 30, 0, 0, time.UTC),
+			expected: time.Date(2025, 3, 10, 4, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "tomorrow",
+			spec:     "0 4 * * *",
+			loc:      time.UTC,
+			after:    time.Date(2025, 3, 10, 4, 0, 0, 0, time.UTC),
+			expected: time.Date(2025, 3, 11, 4, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "in time zone",
+			spec:     "0 4 * * *",
+			loc:      berlin,
+			after:    time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC),
+			expected: time.Date(2025, 1, 11, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "every 15 minutes",
+			spec:     "*/15 * * * *",
+			loc:      time.UTC,
+			after:    time.Date(2025, 3, 10, 1, 16, 30, 0, time.UTC),
+			expected: time.Date(2025, 3, 10, 1, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "weekday",
+			spec:     "0 2 * * sat",
+			loc:      time.UTC,
+			after:    time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC), // Monday
+			expected: time.Date(2025, 3, 15, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "day of month or weekday",
+			spec:     "0 0 1 * sun",
+			loc:      time.UTC,
+			after:    time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2025, 3, 16, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "end of february",
+			spec:     "0 0 29 2 *",
+			loc:      time.UTC,
+			after:    time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "skipped hour on DST start",
+			spec:     "30 2 * * *",
+			loc:      berlin,
+			after:    time.Date(2025, 3, 30, 0, 0, 0, 0, time.UTC), // 01:00 CET
+			expected: time.Date(2025, 3, 31, 0, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "after DST start",
+			spec:     "0 4 * * *",
+			loc:      berlin,
+			after:    time.Date(2025, 3, 30, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2025, 3, 30, 2, 0, 0, 0, time.UTC), // 04:00 CEST
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cron, err := ParseCron(tt.spec, tt.loc)
+			if err != nil {
+				t.Fatalf("ParseCron(%q) error = %v", tt.spec, err)
+			}
+			if next := cron.Next(tt.after); !next.Equal(tt.expected) {
+				t.Errorf("Next(%v) = %v, want %v", tt.after, next.UTC(), tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		name        string
+		spec        string
+		expected    string
+		expectError bool
+	}{
+		{"duration", "6h", "every 6h0m0s", false},
+		{"duration too short", "30s", "", true},
+		{"cron", "0 4 * * *", `cron "0 4 * * *" in UTC`, false},
+		{"invalid", "every night", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := ParseSchedule(tt.spec, time.UTC)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("ParseSchedule(%q) error = %v, expectError %v", tt.spec, err, tt.expectError)
+			}
+			if err == nil && schedule.String() != tt.expected {
+				t.Errorf("ParseSchedule(%q) = %s, want %s", tt.spec, schedule, tt.expected)
+			}
+		})
+	}
+}
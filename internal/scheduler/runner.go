@@ -0,0 +1,247 @@
+package scheduler
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Clock provides the time to a Runner, replaced in tests.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+// Now strips the monotonic clock reading, so comparisons use the wall clock,
+// which keeps running while the host is suspended.
+func (realClock) Now() time.Time {
+	return time.Now().Round(0)
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Backoff configures the retries of failed runs. The delay starts at Min and
+// doubles with every consecutive failure up to Max.
+type Backoff struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+// DefaultBackoff retries failed runs after 1m, 2m, 4m, ... up to an hour.
+var DefaultBackoff = Backoff{Min: time.Minute, Max: time.Hour}
+
+func (b Backoff) delay(failures int) time.Duration {
+	d := b.Min
+	for i := 1; i < failures && d < b.Max; i++ {
+		d *= 2
+	}
+	return min(d, b.Max)
+}
+
+// checkInterval bounds how long the runner sleeps at once. Timers stop while
+// the host is suspended, so waking up regularly is how missed runs are noticed.
+const checkInterval = time.Minute
+
+// Runner runs a function on a schedule.
+type Runner struct {
+	run     func(context.Context) error
+	clock   Clock
+	randN   func(n int64) int64
+	trigger chan struct{}
+
+	// mu guards the settings changed by Reconfigure and WakeAt
+	mu       sync.Mutex
+	schedule Schedule
+	jitter   time.Duration
+	backoff  Backoff
+	wake     time.Time
+}
+
+// RunnerOption configures a Runner.
+type RunnerOption func(*Runner)
+
+// WithClock replaces the wall clock, for tests.
+func WithClock(clock Clock) RunnerOption {
+	return func(r *Runner) {
+		r.clock = clock
+	}
+}
+
+// WithJitter delays every scheduled run by a random duration up to jitter, so
+// several instances do not hit the upstream servers at the same time.
+func WithJitter(jitter time.Duration) RunnerOption {
+	return func(r *Runner) {
+		r.jitter = jitter
+	}
+}
+
+// WithBackoff configures the retries of failed runs.
+func WithBackoff(backoff Backoff) RunnerOption {
+	return func(r *Runner) {
+		r.backoff = backoff
+	}
+}
+
+func NewRunner(schedule Schedule, run func(context.Context) error, opts ...RunnerOption) *Runner {
+	r := &Runner{
+		schedule: schedule,
+		run:      run,
+		clock:    realClock{},
+		backoff:  DefaultBackoff,
+		randN:    rand.Int64N,
+		trigger:  make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Reconfigure replaces the schedule and the options of a running Runner. They
+// take effect after the next run, use Trigger to run right away.
+func (r *Runner) Reconfigure(schedule Schedule, opts ...RunnerOption) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.schedule = schedule
+	for _, opt := range opts {
+		opt(r)
+	}
+}
+
+// WakeAt makes the run after the current one happen at t at the latest, e.g.
+// when something the run depends on expires. It is meant to be called by the
+// function while it runs, or before Run for the first run.
+func (r *Runner) WakeAt(t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.wake.IsZero() || t.Before(r.wake) {
+		r.wake = t
+	}
+}
+
+// Trigger runs the function as soon as possible instead of waiting for the
+// next scheduled time. Triggers during a run cause one more run afterwards.
+func (r *Runner) Trigger() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run runs the function at the scheduled times until ctx is canceled, which is
+// passed to the function to interrupt a running run. Failed
+// runs are retried with an exponential backoff unless the schedule comes
+// first. Runs missed while the host was suspended are caught up once.
+func (r *Runner) Run(ctx context.Context) error {
+	next := r.nextRun(r.clock.Now())
+	failures := 0
+
+	for {
+		if next.IsZero() {
+			slog.Info("No further syncs scheduled")
+		} else {
+			slog.Info("Next sync scheduled", "at", next.Format(time.RFC3339))
+		}
+		triggered, err := r.waitUntil(ctx, next)
+		if err != nil {
+			return err
+		}
+
+		if late := r.clock.Now().Sub(next); late > checkInterval && !triggered && !next.IsZero() {
+			slog.Warn("Missed scheduled sync, running it now", "scheduled_at", next.Format(time.RFC3339), "late", late.Round(time.Second))
+		}
+
+		if triggered {
+			slog.Info("Running triggered sync")
+		} else {
+			slog.Info("Running scheduled sync")
+		}
+		err = r.run(ctx)
+		if ctx.Err() != nil {
+			// The run was interrupted, it is neither a failure nor retried
+			return ctx.Err()
+		}
+		now := r.clock.Now()
+		next = r.nextRun(now)
+		if err == nil {
+			failures = 0
+			continue
+		}
+
+		failures++
+		r.mu.Lock()
+		retry := now.Add(r.backoff.delay(failures))
+		r.mu.Unlock()
+		slog.Error("Scheduled sync failed", "error", err, "failures", failures)
+		if next.IsZero() || retry.Before(next) {
+			slog.Info("Retrying failed sync", "at", retry.Format(time.RFC3339))
+			next = retry
+		}
+	}
+}
+
+// nextScheduled returns the next run of the schedule after t including jitter.
+func (r *Runner) nextScheduled(t time.Time) time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	next := r.schedule.Next(t)
+	if r.jitter > 0 && !next.IsZero() {
+		next = next.Add(time.Duration(r.randN(int64(r.jitter))))
+	}
+	return next
+}
+
+// nextRun returns the next scheduled run after t, or the time set by WakeAt
+// if it comes first.
+func (r *Runner) nextRun(t time.Time) time.Time {
+	next := r.nextScheduled(t)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	wake := r.wake
+	r.wake = time.Time{}
+	if !wake.IsZero() && (next.IsZero() || wake.Before(next)) {
+		return wake
+	}
+	return next
+}
+
+// waitUntil sleeps until the wall clock reaches t or Trigger is called, which
+// is reported by triggered. The zero t waits for Trigger only.
+func (r *Runner) waitUntil(ctx context.Context, t time.Time) (triggered bool, err error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		select {
+		case <-r.trigger:
+			return true, nil
+		default:
+		}
+		if t.IsZero() {
+			select {
+			case <-ctx.Done():
+				return false, ctx.Err()
+			case <-r.trigger:
+				return true, nil
+			}
+		}
+		remaining := t.Sub(r.clock.Now())
+		if remaining <= 0 {
+			return false, nil
+		}
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-r.trigger:
+			return true, nil
+		case <-r.clock.After(min(remaining, checkInterval)):
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// fakeClock advances instantly when slept on. A suspend makes the wall clock
+// jump forward while a sleep passes suspendAt, like on a suspended host.
+type fakeClock struct {
+	now        time.Time
+	suspendAt  time.Time
+	suspendFor time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	before := c.now
+	c.now = c.now.Add(d)
+	if !c.suspendAt.IsZero() && before.Before(c.suspendAt) && !c.now.Before(c.suspendAt) {
+		c.now = c.now.Add(c.suspendFor)
+		c.suspendAt = time.Time{}
+	}
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// runScheduled runs the runner until run was called for every result and
+// returns the times of the calls.
+func runScheduled(t *testing.T, r *Runner, clock *fakeClock, results []error) []time.Time {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var runs []time.Time
+	r.run = func(context.Context) error {
+		runs = append(runs, clock.Now())
+		err := results[len(runs)-1]
+		if len(runs) == len(results) {
+			cancel()
+		}
+		return err
+	}
+
+	if err := r.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want context.Canceled", err)
+	}
+	return runs
+}
+
+func TestRunner(t *testing.T) {
+	start := time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)
+	failed := errors.New("sync failed")
+	daily, err := ParseCron("0 4 * * *", time.UTC)
+	if err != nil {
+		t.Fatalf("ParseCron() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		schedule Schedule
+		opts     []RunnerOption
+		results  []error
+		suspend  time.Time
+		expected []time.Time
+	}{
+		{
+			name:     "interval",
+			schedule: Interval(6 * time.Hour),
+			results:  []error{nil, nil},
+			expected: []time.Time{start.Add(6 * time.Hour), start.Add(12 * time.Hour)},
+		},
+		{
+			name:     "jitter",
+			schedule: daily,
+			opts:     []RunnerOption{WithJitter(10 * time.Minute)},
+			results:  []error{nil, nil},
+			expected: []time.Time{
+				time.Date(2025, 3, 10, 4, 5, 0, 0, time.UTC),
+				time.Date(2025, 3, 11, 4, 5, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:     "failures are retried with backoff",
+			schedule: daily,
+			results:  []error{failed, failed, nil, nil},
+			expected: []time.Time{
+				time.Date(2025, 3, 10, 4, 0, 0, 0, time.UTC),
+				time.Date(2025, 3, 10, 4, 1, 0, 0, time.UTC),
+				time.Date(2025, 3, 10, 4, 3, 0, 0, time.UTC),
+				time.Date(2025, 3, 11, 4, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:     "schedule before retry",
+			schedule: Interval(3 * time.Minute),
+			opts:     []RunnerOption{WithBackoff(Backoff{Min: 2 * time.Minute, Max: time.Hour})},
+			results:  []error{failed, failed, failed},
+			expected: []time.Time{
+				start.Add(3 * time.Minute),
+				start.Add(5 * time.Minute),
+				start.Add(8 * time.Minute),
+			},
+		},
+		{
+			name:     "missed run is caught up",
+			schedule: daily,
+			results:  []error{nil, nil},
+			suspend:  time.Date(2025, 3, 10, 2, 0, 0, 0, time.UTC),
+			expected: []time.Time{
+				time.Date(2025, 3, 10, 8, 0, 0, 0, time.UTC),
+				time.Date(2025, 3, 11, 4, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{now: start, suspendAt: tt.suspend, suspendFor: 6 * time.Hour}
+			r := NewRunner(tt.schedule, nil, append([]RunnerOption{WithClock(clock)}, tt.opts...)...)
+			r.randN = func(n int64) int64 { return n / 2 }
+
+			runs := runScheduled(t, r, clock, tt.results)
+			if !slices.EqualFunc(runs, tt.expected, time.Time.Equal) {
+				t.Errorf("Expected runs at %v, got %v", tt.expected, runs)
+			}
+		})
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Min: time.Minute, Max: 5 * time.Minute}
+	expected := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for i, want := range expected {
+		if got := b.delay(i + 1); got != want {
+			t.Errorf("delay(%d) = %v, want %v", i+1, got, want)
+		}
+	}
+}
+
+func TestRunnerReconfigureAndTrigger(t *testing.T) {
+	start := time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	r := NewRunner(Interval(6*time.Hour), nil, WithClock(clock))
+
+	r.Reconfigure(Interval(time.Hour), WithBackoff(Backoff{Min: time.Minute, Max: time.Minute}))
+	r.Trigger()
+	// Triggers are not queued up
+	r.Trigger()
+
+	runs := runScheduled(t, r, clock, []error{nil, errors.New("sync failed"), nil})
+	expected := []time.Time{start, start.Add(time.Hour), start.Add(time.Hour + time.Minute)}
+	if !slices.EqualFunc(runs, expected, time.Time.Equal) {
+		t.Errorf("Expected runs at %v, got %v", expected, runs)
+	}
+}
+
+func TestRunnerInterrupted(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runs := 0
+	r := NewRunner(Interval(time.Hour), func(ctx context.Context) error {
+		runs++
+		cancel()
+		return ctx.Err()
+	}, WithClock(clock))
+
+	if err := r.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want context.Canceled", err)
+	}
+	if runs != 1 {
+		t.Errorf("Expected 1 run, got %d", runs)
+	}
+}
+
+// never is a schedule without runs, like windows that are over.
+type never struct{}
+
+func (never) Next(time.Time) time.Time { return time.Time{} }
+func (never) String() string           { return "never" }
+
+func TestRunnerWithoutRuns(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)}
+	r := NewRunner(never{}, nil, WithClock(clock), WithJitter(time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- r.Run(ctx) }()
+
+	// Only triggers run, and waiting does not spin
+	select {
+	case err := <-done:
+		t.Fatalf("Run() returned early: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestRunnerWakeAt(t *testing.T) {
+	start := time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	r := NewRunner(Interval(6*time.Hour), nil, WithClock(clock), WithJitter(time.Hour))
+	r.randN = func(n int64) int64 { return n / 2 }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var runs []time.Time
+	r.run = func(context.Context) error {
+		runs = append(runs, clock.Now())
+		switch len(runs) {
+		case 1:
+			// Like a pause expiring before the next scheduled run
+			r.WakeAt(clock.Now().Add(2 * time.Hour))
+		case 3:
+			cancel()
+		}
+		return nil
+	}
+
+	if err := r.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want context.Canceled", err)
+	}
+	// Jitter is not added to the wake up
+	expected := []time.Time{
+		start.Add(6*time.Hour + 30*time.Minute),
+		start.Add(8*time.Hour + 30*time.Minute),
+		start.Add(15 * time.Hour),
+	}
+	if !slices.EqualFunc(runs, expected, time.Time.Equal) {
+		t.Errorf("Expected runs at %v, got %v", expected, runs)
+	}
+}
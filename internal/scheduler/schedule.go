@@ -0,0 +1,44 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schedule decides when syncs run.
+type Schedule interface {
+	// Next returns the first run time after t, or the zero time if there is none.
+	Next(t time.Time) time.Time
+	String() string
+}
+
+// Interval runs a sync a fixed duration after the previous one.
+type Interval time.Duration
+
+func (i Interval) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(i))
+}
+
+func (i Interval) String() string {
+	return "every " + time.Duration(i).String()
+}
+
+// ParseSchedule parses a cron expression like "0 4 * * *", evaluated in loc,
+// or a Go duration like "6h" for backward compatibility with SYNC_INTERVAL.
+func ParseSchedule(spec string, loc *time.Location) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if _, err := time.ParseDuration(spec); err == nil {
+		interval, err := ParseSyncInterval(spec)
+		if err != nil {
+			return nil, err
+		}
+		return Interval(interval), nil
+	}
+
+	cron, err := ParseCron(spec, loc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule '%s': %w", spec, err)
+	}
+	return cron, nil
+}
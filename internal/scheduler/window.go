@@ -0,0 +1,247 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Window is a period of time, once or recurring, in which the managed rules
+// are active.
+type Window interface {
+	// Occurrence returns the occurrence containing t or, if there is none, the
+	// next one. ok is false if the window does not occur after t anymore.
+	Occurrence(t time.Time) (start, end time.Time, ok bool)
+	String() string
+}
+
+// Windows is a set of windows, active whenever one of them is.
+type Windows []Window
+
+// Active reports whether t is within one of the windows.
+func (w Windows) Active(t time.Time) bool {
+	for _, window := range w {
+		if start, _, ok := window.Occurrence(t); ok && !start.After(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextChange returns the first start or end of a window after t, or the zero
+// time if no window occurs anymore.
+func (w Windows) NextChange(t time.Time) time.Time {
+	var next time.Time
+	for _, window := range w {
+		start, end, ok := window.Occurrence(t)
+		if !ok {
+			continue
+		}
+		change := start
+		if !start.After(t) {
+			change = end
+		}
+		if next.IsZero() || change.Before(next) {
+			next = change
+		}
+	}
+	return next
+}
+
+func (w Windows) String() string {
+	specs := make([]string, len(w))
+	for i, window := range w {
+		specs[i] = window.String()
+	}
+	return strings.Join(specs, ", ")
+}
+
+// ParseWindows parses a comma separated list of windows, each either an
+// absolute period like "2025-06-13T18:00/2025-06-15T22:00" or a weekly one
+// like "fri 18:00-sun 22:00". Times without an offset are in loc.
+func ParseWindows(spec string, loc *time.Location) (Windows, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	var windows Windows
+	for part := range strings.SplitSeq(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var window Window
+		var err error
+		if strings.Contains(part, "/") {
+			window, err = parsePeriod(part, loc)
+		} else {
+			window, err = parseWeekly(part, loc)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid window '%s': %w", part, err)
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// period is a window occurring once.
+type period struct {
+	start, end time.Time
+}
+
+var periodLayouts = []string{"2006-01-02T15:04", "2006-01-02T15:04:05", "2006-01-02"}
+
+func parsePeriod(spec string, loc *time.Location) (*period, error) {
+	startStr, endStr, _ := strings.Cut(spec, "/")
+	start, err := parseDateTime(strings.TrimSpace(startStr), loc)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseDateTime(strings.TrimSpace(endStr), loc)
+	if err != nil {
+		return nil, err
+	}
+	if !end.After(start) {
+		return nil, fmt.Errorf("end %s is not after start %s", endStr, startStr)
+	}
+	return &period{start: start, end: end}, nil
+}
+
+func parseDateTime(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range periodLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date and time '%s', expected e.g. 2025-06-13T18:00", s)
+}
+
+func (p *period) Occurrence(t time.Time) (time.Time, time.Time, bool) {
+	return p.start, p.end, t.Before(p.end)
+}
+
+func (p *period) String() string {
+	return p.start.Format(time.RFC3339) + "/" + p.end.Format(time.RFC3339)
+}
+
+// weekly is a window recurring every week.
+type weekly struct {
+	loc *time.Location
+	// start and end are minutes since Sunday midnight
+	start, end int
+}
+
+const minutesPerWeek = 7 * 24 * 60
+
+func parseWeekly(spec string, loc *time.Location) (*weekly, error) {
+	startStr, endStr, ok := strings.Cut(spec, "-")
+	if !ok {
+		return nil, errors.New("expected a period like 2025-06-13T18:00/2025-06-15T22:00 or a weekly window like fri 18:00-sun 22:00")
+	}
+	start, err := parseWeekTime(strings.TrimSpace(startStr))
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseWeekTime(strings.TrimSpace(endStr))
+	if err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, errors.New("start and end are the same")
+	}
+	return &weekly{loc: loc, start: start, end: end}, nil
+}
+
+// parseWeekTime parses a weekday and time like "fri 18:00" into minutes since
+// Sunday midnight.
+func parseWeekTime(s string) (int, error) {
+	dayStr, clock, ok := strings.Cut(s, " ")
+	if !ok {
+		return 0, fmt.Errorf("invalid weekday and time '%s', expected e.g. fri 18:00", s)
+	}
+	day, ok := dowRange.names[strings.ToLower(dayStr)]
+	if !ok {
+		return 0, fmt.Errorf("invalid weekday '%s'", dayStr)
+	}
+	hourStr, minuteStr, ok := strings.Cut(strings.TrimSpace(clock), ":")
+	hour, hourErr := strconv.Atoi(hourStr)
+	minute, minuteErr := strconv.Atoi(minuteStr)
+	if !ok || hourErr != nil || minuteErr != nil || hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return 0, fmt.Errorf("invalid time '%s', expected e.g. 18:00", clock)
+	}
+	return (day*24+hour)*60 + minute, nil
+}
+
+func (w *weekly) Occurrence(t time.Time) (time.Time, time.Time, bool) {
+	local := t.In(w.loc)
+	// The start of the latest occurrence at or before t
+	daysBack := (int(local.Weekday()) - w.start/(24*60) + 7) % 7
+	start := w.at(local.Year(), local.Month(), local.Day()-daysBack, w.start)
+	if start.After(t) {
+		start = w.at(local.Year(), local.Month(), local.Day()-daysBack-7, w.start)
+	}
+	end := w.endOf(start)
+	if !end.After(t) {
+		start = w.at(start.Year(), start.Month(), start.Day()+7, w.start)
+		end = w.endOf(start)
+	}
+	return start, end, true
+}
+
+// at returns the time of minutes into the week on the given day in the location
+// of the window, normalizing days out of range.
+func (w *weekly) at(year int, month time.Month, day, minutes int) time.Time {
+	minutes %= 24 * 60
+	return time.Date(year, month, day, minutes/60, minutes%60, 0, 0, w.loc)
+}
+
+// endOf returns the end of the occurrence starting at start.
+func (w *weekly) endOf(start time.Time) time.Time {
+	length := (w.end - w.start + minutesPerWeek) % minutesPerWeek
+	local := start.In(w.loc)
+	days := (w.start%(24*60) + length) / (24 * 60)
+	return w.at(local.Year(), local.Month(), local.Day()+days, w.end)
+}
+
+func (w *weekly) String() string {
+	return fmt.Sprintf("%s-%s weekly in %s", weekTime(w.start), weekTime(w.end), w.loc)
+}
+
+func weekTime(minutes int) string {
+	days := []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+	return fmt.Sprintf("%s %02d:%02d", days[minutes/(24*60)], minutes/60%24, minutes%60)
+}
+
+// windowedSchedule runs syncs on a schedule within windows and once at the
+// start and end of every window.
+type windowedSchedule struct {
+	schedule Schedule
+	windows  Windows
+}
+
+// InWindows limits schedule to the windows. Additional runs at the start and
+// end of every window apply and remove the managed rules.
+func InWindows(schedule Schedule, windows Windows) Schedule {
+	return &windowedSchedule{schedule: schedule, windows: windows}
+}
+
+func (s *windowedSchedule) Next(t time.Time) time.Time {
+	change := s.windows.NextChange(t)
+	if !s.windows.Active(t) {
+		return change
+	}
+	next := s.schedule.Next(t)
+	if next.IsZero() || (!change.IsZero() && change.Before(next)) {
+		return change
+	}
+	return next
+}
+
+func (s *windowedSchedule) String() string {
+	return fmt.Sprintf("%s within %s", s.schedule, s.windows)
+}
@@ -0,0 +1,171 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindows(t *testing.T) {
+	tests := []struct {
+		name        string
+		spec        string
+		expected    string
+		expectError bool
+	}{
+		{"period", "2025-06-13T18:00/2025-06-15T22:00", "2025-06-13T18:00:00Z/2025-06-15T22:00:00Z", false},
+		{"period with offset", "2025-06-13T18:00:00+02:00/2025-06-15", "2025-06-13T18:00:00+02:00/2025-06-15T00:00:00Z", false},
+		{"weekly", "FRI 18:00 - sun 22:00", "fri 18:00-sun 22:00 weekly in UTC", false},
+		{"list", "sat 10:00-sat 23:00, 2025-06-13/2025-06-16", "sat 10:00-sat 23:00 weekly in UTC, 2025-06-13T00:00:00Z/2025-06-16T00:00:00Z", false},
+		{"end before start", "2025-06-15T22:00/2025-06-13T18:00", "", true},
+		{"invalid date", "2025-13-01/2025-13-02", "", true},
+		{"invalid weekday", "friday 18:00-sun 22:00", "", true},
+		{"invalid time", "fri 25:00-sun 22:00", "", true},
+		{"empty weekly window", "fri 18:00-fri 18:00", "", true},
+		{"neither", "weekends", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			windows, err := ParseWindows(tt.spec, time.UTC)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("ParseWindows(%q) error = %v, expectError %v", tt.spec, err, tt.expectError)
+			}
+			if err == nil && windows.String() != tt.expected {
+				t.Errorf("ParseWindows(%q) = %s, want %s", tt.spec, windows, tt.expected)
+			}
+		})
+	}
+}
+
+func TestWindows(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("Time zone data not available: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		spec           string
+		loc            *time.Location
+		at             time.Time
+		expectedActive bool
+		expectedChange time.Time
+	}{
+		{
+			name:           "before period",
+			spec:           "2025-06-13T18:00/2025-06-15T22:00",
+			loc:            time.UTC,
+			at:             time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC),
+			expectedChange: time.Date(2025, 6, 13, 18, 0, 0, 0, time.UTC),
+		},
+		{
+			name:           "start of period",
+			spec:           "2025-06-13T18:00/2025-06-15T22:00",
+			loc:            time.UTC,
+			at:             time.Date(2025, 6, 13, 18, 0, 0, 0, time.UTC),
+			expectedActive: true,
+			expectedChange: time.Date(2025, 6, 15, 22, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "after period",
+			spec: "2025-06-13T18:00/2025-06-15T22:00",
+			loc:  time.UTC,
+			at:   time.Date(2025, 6, 15, 22, 0, 0, 0, time.UTC),
+		},
+		{
+			name:           "weekly before start",
+			spec:           "fri 18:00-sun 22:00",
+			loc:            time.UTC,
+			at:             time.Date(2025, 3, 12, 12, 0, 0, 0, time.UTC), // Wednesday
+			expectedChange: time.Date(2025, 3, 14, 18, 0, 0, 0, time.UTC),
+		},
+		{
+			name:           "weekly within",
+			spec:           "fri 18:00-sun 22:00",
+			loc:            time.UTC,
+			at:             time.Date(2025, 3, 15, 12, 0, 0, 0, time.UTC), // Saturday
+			expectedActive: true,
+			expectedChange: time.Date(2025, 3, 16, 22, 0, 0, 0, time.UTC),
+		},
+		{
+			name:           "weekly after end",
+			spec:           "fri 18:00-sun 22:00",
+			loc:            time.UTC,
+			at:             time.Date(2025, 3, 16, 23, 0, 0, 0, time.UTC), // Sunday
+			expectedChange: time.Date(2025, 3, 21, 18, 0, 0, 0, time.UTC),
+		},
+		{
+			name:           "weekly across the week",
+			spec:           "sat 20:00-mon 06:00",
+			loc:            time.UTC,
+			at:             time.Date(2025, 3, 17, 5, 0, 0, 0, time.UTC), // Monday
+			expectedActive: true,
+			expectedChange: time.Date(2025, 3, 17, 6, 0, 0, 0, time.UTC),
+		},
+		{
+			name:           "weekly in time zone across DST start",
+			spec:           "sat 18:00-sun 18:00",
+			loc:            berlin,
+			at:             time.Date(2025, 3, 29, 12, 0, 0, 0, time.UTC),
+			expectedChange: time.Date(2025, 3, 29, 17, 0, 0, 0, time.UTC), // 18:00 CET
+		},
+		{
+			name:           "weekly end after DST start",
+			spec:           "sat 18:00-sun 18:00",
+			loc:            berlin,
+			at:             time.Date(2025, 3, 30, 12, 0, 0, 0, time.UTC),
+			expectedActive: true,
+			expectedChange: time.Date(2025, 3, 30, 16, 0, 0, 0, time.UTC), // 18:00 CEST
+		},
+		{
+			name:           "overlapping",
+			spec:           "sat 10:00-sat 23:00, 2025-03-15T20:00/2025-03-16T02:00",
+			loc:            time.UTC,
+			at:             time.Date(2025, 3, 15, 21, 0, 0, 0, time.UTC),
+			expectedActive: true,
+			expectedChange: time.Date(2025, 3, 15, 23, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			windows, err := ParseWindows(tt.spec, tt.loc)
+			if err != nil {
+				t.Fatalf("ParseWindows(%q) error = %v", tt.spec, err)
+			}
+			if active := windows.Active(tt.at); active != tt.expectedActive {
+				t.Errorf("Active(%v) = %v, want %v", tt.at, active, tt.expectedActive)
+			}
+			if change := windows.NextChange(tt.at); !change.Equal(tt.expectedChange) {
+				t.Errorf("NextChange(%v) = %v, want %v", tt.at, change.UTC(), tt.expectedChange)
+			}
+		})
+	}
+}
+
+func TestInWindows(t *testing.T) {
+	windows, err := ParseWindows("sat 10:00-sat 23:00", time.UTC)
+	if err != nil {
+		t.Fatalf("ParseWindows() error = %v", err)
+	}
+	schedule := InWindows(Interval(6*time.Hour), windows)
+
+	tests := []struct {
+		name     string
+		after    time.Time
+		expected time.Time
+	}{
+		{"before window", time.Date(2025, 3, 14, 12, 0, 0, 0, time.UTC), time.Date(2025, 3, 15, 10, 0, 0, 0, time.UTC)},
+		{"within window", time.Date(2025, 3, 15, 10, 0, 0, 0, time.UTC), time.Date(2025, 3, 15, 16, 0, 0, 0, time.UTC)},
+		{"end of window first", time.Date(2025, 3, 15, 20, 0, 0, 0, time.UTC), time.Date(2025, 3, 15, 23, 0, 0, 0, time.UTC)},
+		{"after window", time.Date(2025, 3, 15, 23, 0, 0, 0, time.UTC), time.Date(2025, 3, 22, 10, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if next := schedule.Next(tt.after); !next.Equal(tt.expected) {
+				t.Errorf("Next(%v) = %v, want %v", tt.after, next, tt.expected)
+			}
+		})
+	}
+}
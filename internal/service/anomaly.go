@@ -0,0 +1,183 @@
+package service
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/skaronator/lancache-dns-sync/internal/state"
+	"github.com/skaronator/lancache-dns-sync/internal/types"
+)
+
+// Anomaly describes suspicious upstream data for a service.
+type Anomaly struct {
+	Service string `json:"service"`
+	Reason  string `json:"reason"`
+}
+
+// checkUpstream compares the downloaded rewrites with the data of the last
+// successful sync. Services with anomalies are quarantined: their new rewrites
+// are replaced with the ones of the last successful sync. As the quarantined
+// rewrites are recorded again, a vanished or shrunk service stays quarantined
+// until the removal limits are overridden, which accepts the new data. Domain
+// files that failed to download always quarantine their service, the next sync
+// tries them again.
+func (s *SyncService) checkUpstream(domains *types.CacheDomainsResponse, rewrites []types.DNSRewrite, failedFiles []string, answer string) ([]types.DNSRewrite, []Anomaly) {
+	listed := make(map[string]bool)
+	listedFiles := make(map[string]bool)
+	for _, d := range domains.CacheDomains {
+		listed[d.Name] = true
+		for _, file := range d.DomainFiles {
+			listedFiles[file] = true
+		}
+	}
+	failed := make(map[string]bool)
+	for _, file := range failedFiles {
+		failed[file] = true
+	}
+
+	serviceCounts := make(map[string]int)
+	fileCounts := make(map[string]int)
+	for _, rewrite := range rewrites {
+		serviceCounts[rewrite.Service]++
+		fileCounts[rewrite.Source]++
+	}
+
+	var anomalies []Anomaly
+	quarantined := make(map[string]bool)
+	flag := func(service, reason string) {
+		slog.Warn("Upstream anomaly detected, keeping previous rules for service", "service", service, "reason", reason)
+		anomalies = append(anomalies, Anomaly{Service: service, Reason: reason})
+		quarantined[service] = true
+	}
+	// shrunk reports a vanished or shrunk service, which is accepted when the
+	// removal limits are overridden
+	shrunk := func(service, reason string) {
+		if s.config.RemovalLimits.Override {
+			slog.Warn("Upstream anomaly detected, applying new data as allowed by override", "service", service, "reason", reason)
+			return
+		}
+		flag(service, reason)
+	}
+	shrinks := func(previousCount, count int) bool {
+		shrink := float64(previousCount-count) * 100 / float64(previousCount)
+		return s.config.AnomalyShrinkPercent > 0 && shrink > s.config.AnomalyShrinkPercent
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(s.state.Services)) {
+		if !s.config.HasService(name) {
+			continue
+		}
+		if !listed[name] {
+			shrunk(name, "service vanished from cache_domains.json")
+			continue
+		}
+
+		previous := s.state.Services[name]
+		previousCount := previous.DomainCount()
+		if previousCount == 0 {
+			continue
+		}
+		if file := failedFile(previous, failed); file != "" {
+			flag(name, fmt.Sprintf("domain file %s could not be downloaded", file))
+			continue
+		}
+
+		// Files are compared one by one, so one collapsed file is not hidden
+		// by the others of the service. Files no longer listed were renamed
+		// or removed upstream and only count towards the service total.
+		reason := ""
+		for _, file := range slices.Sorted(maps.Keys(previous.Files)) {
+			fileCount := len(previous.Files[file])
+			if listedFiles[file] && fileCount > 0 && shrinks(fileCount, fileCounts[file]) {
+				reason = fmt.Sprintf("domain file %s shrank from %d to %d domains", file, fileCount, fileCounts[file])
+				break
+			}
+		}
+		if count := serviceCounts[name]; reason == "" && shrinks(previousCount, count) {
+			reason = fmt.Sprintf("shrank from %d to %d domains", previousCount, count)
+		}
+		if reason != "" {
+			shrunk(name, reason)
+		}
+	}
+
+	for _, rewrite := range rewrites {
+		if !quarantined[rewrite.Service] && isSuspiciousDomain(rewrite.Domain) {
+			flag(rewrite.Service, fmt.Sprintf("suspicious entry %q in %s", rewrite.Domain, rewrite.Source))
+		}
+	}
+
+	if len(quarantined) == 0 {
+		return rewrites, nil
+	}
+
+	var result []types.DNSRewrite
+	for _, rewrite := range rewrites {
+		if !quarantined[rewrite.Service] {
+			result = append(result, rewrite)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(quarantined)) {
+		previous := s.state.Services[name]
+		for _, file := range slices.Sorted(maps.Keys(previous.Files)) {
+			for _, domain := range previous.Files[file] {
+				result = append(result, types.DNSRewrite{
+					Domain:  domain,
+					Answer:  answer,
+					Service: name,
+					Source:  file,
+				})
+			}
+		}
+	}
+	return result, anomalies
+}
+
+// failedFile returns the first domain file of the service that could not be
+// downloaded, or "" if all were downloaded.
+func failedFile(service state.ServiceState, failed map[string]bool) string {
+	for _, file := range slices.Sorted(maps.Keys(service.Files)) {
+		if failed[file] {
+			return file
+		}
+	}
+	return ""
+}
+
+// isSuspiciousDomain reports entries that would redirect a whole top level
+// domain or everything, like "*.com" or "*".
+func isSuspiciousDomain(domain string) bool {
+	domain = strings.TrimPrefix(domain, "*.")
+	return domain == "" || domain == "*" || !strings.Contains(strings.Trim(domain, "."), ".")
+}
+
+// recordSync remembers the applied rewrites as the data of the last
+// successful sync and persists it if a state directory is configured.
+func (s *SyncService) recordSync(rewrites []types.DNSRewrite) {
+	services := make(map[string]state.ServiceState)
+	for _, rewrite := range rewrites {
+		service, ok := services[rewrite.Service]
+		if !ok {
+			service = state.ServiceState{Files: make(map[string][]string)}
+			services[rewrite.Service] = service
+		}
+		service.Files[rewrite.Source] = append(service.Files[rewrite.Source], rewrite.Domain)
+	}
+
+	s.state.Services = services
+	s.state.LastSync = now().UTC()
+	s.saveState()
+}
+
+// saveState persists the state if a state directory is configured.
+func (s *SyncService) saveState() {
+	if s.stateStore == nil {
+		return
+	}
+	if err := s.stateStore.Save(s.state); err != nil {
+		slog.Warn("Failed to save sync state", "error", err)
+	}
+}
@@ -0,0 +1,267 @@
+package service
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/skaronator/lancache-dns-sync/internal/config"
+	"github.com/skaronator/lancache-dns-sync/internal/state"
+	"github.com/skaronator/lancache-dns-sync/internal/types"
+)
+
+func steamRewrites(count int, source string) []types.DNSRewrite {
+	var rewrites []types.DNSRewrite
+	for i := range count {
+		rewrites = append(rewrites, types.DNSRewrite{
+			Domain:  fmt.Sprintf("cdn%d.steam.com", i),
+			Answer:  "192.168.1.1",
+			Service: "steam",
+			Source:  source,
+		})
+	}
+	return rewrites
+}
+
+func TestSyncService_CheckUpstream(t *testing.T) {
+	bothServices := &types.CacheDomainsResponse{
+		CacheDomains: []types.CacheDomain{
+			{Name: "steam", DomainFiles: []string{"steam.txt"}},
+			{Name: "origin", DomainFiles: []string{"origin.txt"}},
+		},
+	}
+	renamed := &types.CacheDomainsResponse{
+		CacheDomains: []types.CacheDomain{
+			{Name: "steam", DomainFiles: []string{"steam_v2.txt"}},
+			{Name: "origin", DomainFiles: []string{"origin.txt"}},
+		},
+	}
+	originOnly := &types.CacheDomainsResponse{
+		CacheDomains: []types.CacheDomain{
+			{Name: "origin", DomainFiles: []string{"origin.txt"}},
+		},
+	}
+	origin := types.DNSRewrite{Domain: "origin.com", Answer: "192.168.1.1", Service: "origin", Source: "origin.txt"}
+
+	tests := []struct {
+		name              string
+		serviceNames      []string
+		domains           *types.CacheDomainsResponse
+		rewrites          []types.DNSRewrite
+		failedFiles       []string
+		override          bool
+		expectQuarantined []string
+		expectSteamCount  int
+	}{
+		{
+			name:             "no anomalies",
+			serviceNames:     []string{"*"},
+			domains:          bothServices,
+			rewrites:         append(steamRewrites(9, "steam.txt"), origin),
+			expectSteamCount: 9,
+		},
+		{
+			name:              "service vanished",
+			serviceNames:      []string{"*"},
+			domains:           originOnly,
+			rewrites:          []types.DNSRewrite{origin},
+			expectQuarantined: []string{"steam"},
+			expectSteamCount:  10,
+		},
+		{
+			name:             "deselected service is not an anomaly",
+			serviceNames:     []string{"origin"},
+			domains:          originOnly,
+			rewrites:         []types.DNSRewrite{origin},
+			expectSteamCount: 0,
+		},
+		{
+			name:              "domain file shrank",
+			serviceNames:      []string{"*"},
+			domains:           bothServices,
+			rewrites:          append(steamRewrites(2, "steam.txt"), origin),
+			expectQuarantined: []string{"steam"},
+			expectSteamCount:  10,
+		},
+		{
+			name:             "domain file renamed",
+			serviceNames:     []string{"*"},
+			domains:          renamed,
+			rewrites:         append(steamRewrites(10, "steam_v2.txt"), origin),
+			expectSteamCount: 10,
+		},
+		{
+			name:              "domain file failed to download",
+			serviceNames:      []string{"*"},
+			domains:           bothServices,
+			rewrites:          []types.DNSRewrite{origin},
+			failedFiles:       []string{"steam.txt"},
+			override:          true,
+			expectQuarantined: []string{"steam"},
+			expectSteamCount:  10,
+		},
+		{
+			name:             "override accepts shrink",
+			serviceNames:     []string{"*"},
+			domains:          bothServices,
+			rewrites:         append(steamRewrites(2, "steam.txt"), origin),
+			override:         true,
+			expectSteamCount: 2,
+		},
+		{
+			name:             "override accepts vanished service",
+			serviceNames:     []string{"*"},
+			domains:          originOnly,
+			rewrites:         []types.DNSRewrite{origin},
+			override:         true,
+			expectSteamCount: 0,
+		},
+		{
+			name:         "bare tld wildcard",
+			serviceNames: []string{"*"},
+			domains:      bothServices,
+			rewrites: append(steamRewrites(10, "steam.txt"), origin,
+				types.DNSRewrite{Domain: "*.com", Answer: "192.168.1.1", Service: "origin", Source: "origin.txt"}),
+			expectQuarantined: []string{"origin"},
+			expectSteamCount:  10,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				ServiceNames:         tt.serviceNames,
+				AnomalyShrinkPercent: 50,
+				RemovalLimits:        config.RemovalLimits{Override: tt.override},
+			}
+			service := NewSyncService(&mockAdguardClient{}, nil, cfg)
+			service.recordSync(append(steamRewrites(10, "steam.txt"), origin))
+
+			rewrites, anomalies := service.checkUpstream(tt.domains, tt.rewrites, tt.failedFiles, "192.168.1.1")
+
+			var quarantined []string
+			for _, anomaly := range anomalies {
+				quarantined = append(quarantined, anomaly.Service)
+			}
+			if !slices.Equal(quarantined, tt.expectQuarantined) {
+				t.Errorf("Expected quarantined services %v, got %v (%v)", tt.expectQuarantined, quarantined, anomalies)
+			}
+
+			steamCount := 0
+			for _, rewrite := range rewrites {
+				if rewrite.Service == "steam" {
+					steamCount++
+				}
+				if rewrite.Domain == "*.com" {
+					t.Error("Expected suspicious entry to be dropped")
+				}
+			}
+			if steamCount != tt.expectSteamCount {
+				t.Errorf("Expected %d steam rewrites, got %d", tt.expectSteamCount, steamCount)
+			}
+		})
+	}
+}
+
+func TestSyncService_CheckUpstreamFileCollapsed(t *testing.T) {
+	domains := &types.CacheDomainsResponse{
+		CacheDomains: []types.CacheDomain{{Name: "steam", DomainFiles: []string{"steam.txt", "steam-extra.txt"}}},
+	}
+	extra := []types.DNSRewrite{
+		{Domain: "steam.example.com", Answer: "192.168.1.1", Service: "steam", Source: "steam-extra.txt"},
+		{Domain: "steam.example.net", Answer: "192.168.1.1", Service: "steam", Source: "steam-extra.txt"},
+	}
+	cfg := &config.Config{ServiceNames: []string{"*"}, AnomalyShrinkPercent: 50}
+	service := NewSyncService(&mockAdguardClient{}, nil, cfg)
+	service.recordSync(append(steamRewrites(10, "steam.txt"), extra...))
+
+	// steam-extra.txt lost all domains, which the service total of 10 of 12
+	// would hide
+	rewrites, anomalies := service.checkUpstream(domains, steamRewrites(10, "steam.txt"), nil, "192.168.1.1")
+	expected := []Anomaly{{Service: "steam", Reason: "domain file steam-extra.txt shrank from 2 to 0 domains"}}
+	if !slices.Equal(anomalies, expected) {
+		t.Errorf("Expected anomalies %v, got %v", expected, anomalies)
+	}
+	if len(rewrites) != 12 {
+		t.Errorf("Expected the previous 12 steam rewrites, got %d", len(rewrites))
+	}
+}
+
+func TestSyncService_QuarantineReleased(t *testing.T) {
+	domains := &types.CacheDomainsResponse{
+		CacheDomains: []types.CacheDomain{{Name: "steam", DomainFiles: []string{"steam.txt"}}},
+	}
+	cfg := &config.Config{ServiceNames: []string{"*"}, AnomalyShrinkPercent: 50}
+	service := NewSyncService(&mockAdguardClient{}, nil, cfg)
+	service.recordSync(steamRewrites(10, "steam.txt"))
+
+	// The quarantined data is recorded again, so the service stays quarantined
+	for range 2 {
+		rewrites, anomalies := service.checkUpstream(domains, steamRewrites(2, "steam.txt"), nil, "192.168.1.1")
+		if len(anomalies) != 1 || len(rewrites) != 10 {
+			t.Fatalf("Expected steam to stay quarantined, got %d rewrites and %v", len(rewrites), anomalies)
+		}
+		service.recordSync(rewrites)
+	}
+
+	// Overriding the removal limits accepts the new data for good
+	cfg.RemovalLimits.Override = true
+	rewrites, anomalies := service.checkUpstream(domains, steamRewrites(2, "steam.txt"), nil, "192.168.1.1")
+	if len(anomalies) != 0 || len(rewrites) != 2 {
+		t.Fatalf("Expected the new data with override, got %d rewrites and %v", len(rewrites), anomalies)
+	}
+	service.recordSync(rewrites)
+
+	cfg.RemovalLimits.Override = false
+	if _, anomalies := service.checkUpstream(domains, steamRewrites(2, "steam.txt"), nil, "192.168.1.1"); len(anomalies) != 0 {
+		t.Errorf("Expected no anomaly after the override, got %v", anomalies)
+	}
+	if expected := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC); !service.state.LastSync.Equal(expected) {
+		t.Errorf("Expected LastSync %v, got %v", expected, service.state.LastSync)
+	}
+}
+
+func TestIsSuspiciousDomain(t *testing.T) {
+	tests := []struct {
+		domain   string
+		expected bool
+	}{
+		{"*.com", true},
+		{"com", true},
+		{"*", true},
+		{"*.", true},
+		{"*.steamcontent.com", false},
+		{"steampowered.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			if got := isSuspiciousDomain(tt.domain); got != tt.expected {
+				t.Errorf("isSuspiciousDomain(%s) = %v, want %v", tt.domain, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSyncService_StatePersisted(t *testing.T) {
+	cfg := &config.Config{StateDir: t.TempDir()}
+	service := NewSyncService(&mockAdguardClient{}, nil, cfg)
+	service.recordSync(steamRewrites(3, "steam.txt"))
+
+	st, err := state.NewStore(filepath.Join(cfg.StateDir, "state.json")).Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if st.Services["steam"].DomainCount() != 3 {
+		t.Errorf("Expected 3 persisted steam domains, got %d", st.Services["steam"].DomainCount())
+	}
+
+	// A new service instance picks up the persisted state
+	restarted := NewSyncService(&mockAdguardClient{}, nil, cfg)
+	restarted.loadState()
+	if restarted.state.Services["steam"].DomainCount() != 3 {
+		t.Errorf("Expected state to be loaded, got %+v", restarted.state)
+	}
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/skaronator/lancache-dns-sync/internal/config"
+	"github.com/skaronator/lancache-dns-sync/internal/types"
+)
+
+// CleanupResult describes what Cleanup removed, or would remove in a dry run.
+type CleanupResult struct {
+	// RemovedRules are the managed rules and markers removed from the user rules.
+	RemovedRules []string
+	// FilterListURL is set when the registered filter list was removed.
+	FilterListURL string
+	DryRun        bool
+}
+
+// CleanupOptions control what Cleanup changes.
+type CleanupOptions struct {
+	// DryRun reports what would be removed without writing anything.
+	DryRun bool
+	// DropCorruptedMarkers removes only the markers when they are corrupted
+	// instead of failing with ErrCorruptedMarkers. The managed rules then stay
+	// in place as ordinary user rules.
+	DropCorruptedMarkers bool
+}
+
+// Cleanup removes everything this instance added to AdGuard: its managed
+// section from the user rules and, in filter list mode, the registered filter
+// list.
+func (s *SyncService) Cleanup(ctx context.Context, opts CleanupOptions) (*CleanupResult, error) {
+	s.loadState()
+
+	status, err := s.client.GetFilteringStatus(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get filtering status: %w", err)
+	}
+
+	result := &CleanupResult{DryRun: opts.DryRun}
+
+	var preservedRules []string
+	existingRules, err := s.checkMarkers(status.UserRules)
+	switch {
+	case err == nil:
+		preservedRules = extractNonManagedRules(existingRules, s.markers)
+	case opts.DropCorruptedMarkers:
+		// The managed rules cannot be told apart from the user's own rules,
+		// so only the markers are removed
+		slog.Warn("Removing only the markers of the managed section", "error", err)
+		preservedRules = withoutMarkers(status.UserRules, s.markers)
+	default:
+		return nil, err
+	}
+	result.RemovedRules = removedRules(status.UserRules, preservedRules)
+
+	if s.config.FilterList.URL != "" && slices.ContainsFunc(status.Filters, func(f types.Filter) bool {
+		return f.URL == s.config.FilterList.URL
+	}) {
+		result.FilterListURL = s.config.FilterList.URL
+	}
+
+	if opts.DryRun {
+		slog.Info("Dry run, not changing AdGuard",
+			"rules_to_remove", len(result.RemovedRules),
+			"filter_list_to_remove", result.FilterListURL)
+		return result, nil
+	}
+
+	// Both changes are made once started
+	ctx, err = writeContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.RemovedRules) > 0 {
+		if err := s.setFilteringRules(ctx, status.UserRules, preservedRules); err != nil {
+			return nil, fmt.Errorf("failed to set filtering rules: %w", err)
+		}
+		slog.Info("Removed managed section from user rules", "removed_rules", len(result.RemovedRules))
+		s.state.ManagedBlock = nil
+		s.saveState()
+	}
+
+	if result.FilterListURL != "" {
+		if err := s.client.RemoveFilterURL(ctx, result.FilterListURL); err != nil {
+			return nil, fmt.Errorf("failed to remove filter list: %w", err)
+		}
+		slog.Info("Removed filter list from AdGuard", "url", result.FilterListURL)
+	}
+
+	return result, nil
+}
+
+// removedRules returns the rules of all that are not part of preserved.
+// preserved must be an ordered subset of all.
+func removedRules(all, preserved []string) []string {
+	removed := []string{}
+	i := 0
+	for _, rule := range all {
+		if i < len(preserved) && preserved[i] == rule {
+			i++
+			continue
+		}
+		removed = append(removed, rule)
+	}
+	return removed
+}
+
+// removeManagedRules runs the cleanup as part of a sync, for syncs that must
+// not redirect anything. Nothing is written when the managed rules are
+// already gone.
+func (s *SyncService) removeManagedRules(ctx context.Context) error {
+	if s.config.SyncMode == config.SyncModeFilterList {
+		s.filterList.SetRules(nil)
+	}
+
+	phaseStart := time.Now()
+	result, err := s.Cleanup(ctx, CleanupOptions{DryRun: s.config.DryRun})
+	s.lastResult.Timings.Apply = time.Since(phaseStart)
+	target := TargetResult{Target: s.config.SyncMode}
+	if err != nil {
+		target.Error = err.Error()
+	} else {
+		target.Written = !result.DryRun && (len(result.RemovedRules) > 0 || result.FilterListURL != "")
+	}
+	s.lastResult.AdguardWritten = target.Written
+	s.lastResult.Targets = append(s.lastResult.Targets, target)
+	return err
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/skaronator/lancache-dns-sync/internal/config"
+	"github.com/skaronator/lancache-dns-sync/internal/types"
+)
+
+func TestSyncService_Cleanup(t *testing.T) {
+	const listURL = "http://lancache-dns-sync:8080/filter.txt"
+	userRules := []string{
+		"||before.com^",
+		startMarker,
+		"|managed.com^$dnsrewrite=192.168.1.1",
+		endMarker,
+		"||after.com^",
+		endMarker,
+	}
+
+	tests := []struct {
+		name              string
+		dryRun            bool
+		filters           []types.Filter
+		filterListURL     string
+		expectRemoved     []string
+		expectFilterList  string
+		expectSetRules    bool
+		expectRemovedList string
+	}{
+		{
+			name:   "dry run",
+			dryRun: true,
+			expectRemoved: []string{
+				startMarker,
+				"|managed.com^$dnsrewrite=192.168.1.1",
+				endMarker,
+				endMarker,
+			},
+		},
+		{
+			name: "remove managed section and stray markers",
+			expectRemoved: []string{
+				startMarker,
+				"|managed.com^$dnsrewrite=192.168.1.1",
+				endMarker,
+				endMarker,
+			},
+			expectSetRules: true,
+		},
+		{
+			name:          "remove registered filter list",
+			filters:       []types.Filter{{URL: listURL}},
+			filterListURL: listURL,
+			expectRemoved: []string{
+				startMarker,
+				"|managed.com^$dnsrewrite=192.168.1.1",
+				endMarker,
+				endMarker,
+			},
+			expectFilterList:  listURL,
+			expectSetRules:    true,
+			expectRemovedList: listURL,
+		},
+		{
+			name:             "dry run with filter list",
+			dryRun:           true,
+			filters:          []types.Filter{{URL: listURL}},
+			filterListURL:    listURL,
+			expectFilterList: listURL,
+			expectRemoved: []string{
+				startMarker,
+				"|managed.com^$dnsrewrite=192.168.1.1",
+				endMarker,
+				endMarker,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mockAdguardClient{
+				filteringStatus: &types.FilterStatus{UserRules: userRules, Filters: tt.filters},
+			}
+			cfg := &config.Config{FilterList: config.FilterListConfig{URL: tt.filterListURL}}
+			service := NewSyncService(client, nil, cfg)
+
+			result, err := service.Cleanup(context.Background(), CleanupOptions{DryRun: tt.dryRun})
+			if err != nil {
+				t.Fatalf("Cleanup() error = %v", err)
+			}
+
+			if !slices.Equal(result.RemovedRules, tt.expectRemoved) {
+				t.Errorf("Expected removed rules %v, got %v", tt.expectRemoved, result.RemovedRules)
+			}
+			if result.FilterListURL != tt.expectFilterList {
+				t.Errorf("Expected filter list %q, got %q", tt.expectFilterList, result.FilterListURL)
+			}
+			if client.setRulesCalled != tt.expectSetRules {
+				t.Errorf("Expected SetFilteringRules called = %v, got %v", tt.expectSetRules, client.setRulesCalled)
+			}
+			if tt.expectSetRules && !slices.Equal(client.lastRules, []string{"||before.com^", "||after.com^"}) {
+				t.Errorf("Expected only non-managed rules, got %v", client.lastRules)
+			}
+			if client.removedFilterURL != tt.expectRemovedList {
+				t.Errorf("Expected removed filter list %q, got %q", tt.expectRemovedList, client.removedFilterURL)
+			}
+		})
+	}
+}
+
+func TestSyncService_CleanupNothingToDo(t *testing.T) {
+	client := &mockAdguardClient{
+		filteringStatus: &types.FilterStatus{UserRules: []string{"||custom.com^"}},
+	}
+	service := NewSyncService(client, nil, &config.Config{})
+
+	result, err := service.Cleanup(context.Background(), CleanupOptions{})
+	if err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+	if len(result.RemovedRules) != 0 {
+		t.Errorf("Expected no removed rules, got %v", result.RemovedRules)
+	}
+	if client.setRulesCalled {
+		t.Error("Expected SetFilteringRules not to be called")
+	}
+}
+
+func TestSyncService_CleanupCorruptedMarkers(t *testing.T) {
+	userRules := []string{
+		startMarker,
+		"|managed.com^$dnsrewrite=192.168.1.1",
+		"||custom.com^",
+	}
+
+	t.Run("refuses to write", func(t *testing.T) {
+		client := &mockAdguardClient{filteringStatus: &types.FilterStatus{UserRules: userRules}}
+		service := NewSyncService(client, nil, &config.Config{})
+
+		if _, err := service.Cleanup(context.Background(), CleanupOptions{}); !errors.Is(err, ErrCorruptedMarkers) {
+			t.Fatalf("Expected ErrCorruptedMarkers, got %v", err)
+		}
+		if client.setRulesCalled {
+			t.Error("Expected SetFilteringRules not to be called")
+		}
+	})
+
+	t.Run("drops only the markers when asked to", func(t *testing.T) {
+		client := &mockAdguardClient{filteringStatus: &types.FilterStatus{UserRules: userRules}}
+		service := NewSyncService(client, nil, &config.Config{})
+
+		// Without an end marker the managed rules are unknown, only the marker goes
+		result, err := service.Cleanup(context.Background(), CleanupOptions{DropCorruptedMarkers: true})
+		if err != nil {
+			t.Fatalf("Cleanup() error = %v", err)
+		}
+		if !slices.Equal(result.RemovedRules, []string{startMarker}) {
+			t.Errorf("Expected only the marker to be removed, got %v", result.RemovedRules)
+		}
+		expected := []string{"|managed.com^$dnsrewrite=192.168.1.1", "||custom.com^"}
+		if !slices.Equal(client.lastRules, expected) {
+			t.Errorf("Expected rules %v, got %v", expected, client.lastRules)
+		}
+	})
+}
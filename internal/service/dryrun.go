@@ -0,0 +1,155 @@
+package service
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/skaronator/lancache-dns-sync/internal/diff"
+	"github.com/skaronator/lancache-dns-sync/internal/state"
+	"github.com/skaronator/lancache-dns-sync/internal/types"
+)
+
+const diffContext = 3
+
+// DomainChange lists the domains a sync adds to or removes from the managed
+// section for one service.
+type DomainChange struct {
+	Service string
+	Added   []string
+	Removed []string
+}
+
+// writeDryRun prints the diff between the current and the proposed user rules
+// followed by the added and removed domains per service.
+// The service of removed domains is taken from the service comments of the
+// managed section or, for sections without them, from the state of the last sync.
+func writeDryRun(w io.Writer, currentRules, proposedRules []string, rewrites []types.DNSRewrite, m markers, st *state.State) error {
+	unified := diff.Unified("current user rules", "proposed user rules", currentRules, proposedRules, diffContext)
+	if unified == "" {
+		unified = "No changes to the user rules\n"
+	}
+
+	var b strings.Builder
+	b.WriteString(unified)
+
+	changes := domainChanges(currentRules, rewrites, m, st)
+	if len(changes) > 0 {
+		b.WriteString("\nDomain changes per service:\n")
+		for _, change := range changes {
+			service := change.Service
+			if service == "" {
+				service = "unknown"
+			}
+			fmt.Fprintf(&b, "  %s: +%d -%d\n", service, len(change.Added), len(change.Removed))
+			for _, domain := range change.Added {
+				fmt.Fprintf(&b, "    + %s\n", domain)
+			}
+			for _, domain := range change.Removed {
+				fmt.Fprintf(&b, "    - %s\n", domain)
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// domainChanges compares the domains of the managed section in rules with the
+// domains of rewrites, grouped by service.
+func domainChanges(rules []string, rewrites []types.DNSRewrite, m markers, st *state.State) []DomainChange {
+	synced := make(map[string]string)
+	for name, svc := range st.Services {
+		for _, domains := range svc.Files {
+			for _, domain := range domains {
+				synced[domain] = name
+			}
+		}
+	}
+
+	current := make(map[string]string)
+	service := ""
+	for _, rule := range removedRules(rules, extractNonManagedRules(rules, m)) {
+		if name, ok := serviceFromHeader(rule); ok {
+			service = name
+			continue
+		}
+		if domain, ok := ruleDomain(rule); ok {
+			current[domain] = cmp.Or(service, synced[domain])
+		}
+	}
+
+	proposed := make(map[string]string)
+	for _, rewrite := range rewrites {
+		proposed[rewrite.Domain] = rewrite.Service
+	}
+
+	byService := make(map[string]*DomainChange)
+	change := func(service string) *DomainChange {
+		if byService[service] == nil {
+			byService[service] = &DomainChange{Service: service}
+		}
+		return byService[service]
+	}
+
+	for domain, service := range proposed {
+		if _, ok := current[domain]; !ok {
+			c := change(service)
+			c.Added = append(c.Added, domain)
+		}
+	}
+	for domain, service := range current {
+		if _, ok := proposed[domain]; !ok {
+			c := change(service)
+			c.Removed = append(c.Removed, domain)
+		}
+	}
+
+	changes := make([]DomainChange, 0, len(byService))
+	for _, c := range byService {
+		slices.Sort(c.Added)
+		slices.Sort(c.Removed)
+		changes = append(changes, *c)
+	}
+	slices.SortFunc(changes, func(a, b DomainChange) int {
+		return cmp.Compare(a.Service, b.Service)
+	})
+	return changes
+}
+
+// serviceFromHeader returns the service named by a service comment of the
+// managed section, see serviceHeader.
+func serviceFromHeader(rule string) (string, bool) {
+	rest, ok := strings.CutPrefix(rule, "# service: ")
+	if !ok {
+		return "", false
+	}
+	name, _, _ := strings.Cut(rest, " (")
+	return name, name != ""
+}
+
+// ruleDomain returns the domain a generated rewrite rule matches, using the
+// "*." prefix for rules matching all subdomains.
+func ruleDomain(rule string) (string, bool) {
+	var wildcard bool
+	switch {
+	case strings.HasPrefix(rule, "||"):
+		wildcard = true
+		rule = rule[2:]
+	case strings.HasPrefix(rule, "|"):
+		rule = rule[1:]
+	default:
+		return "", false
+	}
+
+	domain, _, found := strings.Cut(rule, "^")
+	if !found || domain == "" {
+		return "", false
+	}
+	if wildcard {
+		domain = "*." + domain
+	}
+	return domain, true
+}
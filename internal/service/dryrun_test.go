@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/skaronator/lancache-dns-sync/internal/config"
+	"github.com/skaronator/lancache-dns-sync/internal/state"
+	"github.com/skaronator/lancache-dns-sync/internal/types"
+)
+
+func TestSyncService_DryRun(t *testing.T) {
+	client := &mockAdguardClient{
+		filteringStatus: &types.FilterStatus{
+			UserRules: []string{
+				"||custom.com^",
+				startMarker,
+				"# service: steam (2 domains)",
+				"|old.steam.com^$dnsrewrite=192.168.1.1",
+				"|keep.steam.com^$dnsrewrite=192.168.1.1",
+				endMarker,
+			},
+		},
+	}
+	service := NewSyncService(client, nil, &config.Config{DryRun: true})
+	var out strings.Builder
+	service.out = &out
+
+	rewrites := []types.DNSRewrite{
+		{Domain: "keep.steam.com", Answer: "192.168.1.1", Service: "steam"},
+		{Domain: "*.new.steam.com", Answer: "192.168.1.1", Service: "steam"},
+		{Domain: "origin.com", Answer: "192.168.1.1", Service: "origin"},
+	}
+	if err := service.UpdateFilteringRules(context.Background(), rewrites); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+
+	if client.setRulesCalled {
+		t.Error("Expected SetFilteringRules not to be called in dry run")
+	}
+
+	expected := `--- current user rules
++++ proposed user rules
+@@ -1,6 +1,9 @@
+ ||custom.com^
+ # lancache-dns-sync start
++# lancache-dns-sync dev, updated 2025-01-01T00:00:00Z, upstream commit unknown
+ # service: steam (2 domains)
+-|old.steam.com^$dnsrewrite=192.168.1.1
+ |keep.steam.com^$dnsrewrite=192.168.1.1
++||new.steam.com^$dnsrewrite=192.168.1.1
++# service: origin (1 domain)
++|origin.com^$dnsrewrite=192.168.1.1
+ # lancache-dns-sync end
+
+Domain changes per service:
+  origin: +1 -0
+    + origin.com
+  steam: +1 -1
+    + *.new.steam.com
+    - old.steam.com
+`
+	if out.String() != expected {
+		t.Errorf("Unexpected dry run output:\n%s\nwant\n%s", out.String(), expected)
+	}
+}
+
+func TestSyncService_DryRunUnchanged(t *testing.T) {
+	client := &mockAdguardClient{
+		filteringStatus: &types.FilterStatus{
+			UserRules: []string{
+				"||custom.com^",
+				startMarker,
+				"# lancache-dns-sync v1.0.0, updated 2024-06-01T00:00:00Z, upstream commit abc",
+				"# service: steam (1 domain)",
+				"|keep.steam.com^$dnsrewrite=192.168.1.1",
+				endMarker,
+			},
+		},
+	}
+	service := NewSyncService(client, nil, &config.Config{DryRun: true})
+	var out strings.Builder
+	service.out = &out
+
+	rewrites := []types.DNSRewrite{{Domain: "keep.steam.com", Answer: "192.168.1.1", Service: "steam"}}
+	if err := service.UpdateFilteringRules(context.Background(), rewrites); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+
+	// The header keeps the time of the last change
+	if out.String() != "No changes to the user rules\n" {
+		t.Errorf("Unexpected dry run output:\n%s", out.String())
+	}
+}
+
+func TestDomainChanges(t *testing.T) {
+	// A managed section written before the service comments were added
+	rules := []string{
+		startMarker,
+		"|old.steam.com^$dnsrewrite=192.168.1.1",
+		"|gone.example.com^$dnsrewrite=192.168.1.1",
+		endMarker,
+	}
+	st := state.New()
+	st.Services["steam"] = state.ServiceState{Files: map[string][]string{"steam.txt": {"old.steam.com"}}}
+
+	changes := domainChanges(rules, nil, newMarkers(""), st)
+	expected := []DomainChange{
+		{Service: "", Removed: []string{"gone.example.com"}},
+		{Service: "steam", Removed: []string{"old.steam.com"}},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("domainChanges() = %+v, want %+v", changes, expected)
+	}
+}
+
+func TestRuleDomain(t *testing.T) {
+	tests := []struct {
+		rule     string
+		expected string
+		ok       bool
+	}{
+		{"||cdn.blizzard.com^$dnsrewrite=192.168.1.1", "*.cdn.blizzard.com", true},
+		{"|cdn.blizzard.com^$dnsrewrite=192.168.1.1,ctag=device_pc", "cdn.blizzard.com", true},
+		{startMarker, "", false},
+		{"@@||example.com^", "", false},
+		{"|^$dnsrewrite=192.168.1.1", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			domain, ok := ruleDomain(tt.rule)
+			if domain != tt.expected || ok != tt.ok {
+				t.Errorf("ruleDomain(%s) = %s, %v, want %s, %v", tt.rule, domain, ok, tt.expected, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/skaronator/lancache-dns-sync/internal/state"
+	"github.com/skaronator/lancache-dns-sync/internal/types"
+)
+
+// ErrMassRemoval is returned when a sync would remove more managed rules than
+// the configured limits allow.
+var ErrMassRemoval = errors.New("refusing to remove too many managed rules")
+
+// checkRemovalLimits guards against replacing the managed rules with a small
+// subset, e.g. when upstream temporarily serves an empty or truncated file set.
+// Removals are counted by domain, so changing the rendering of the rules, like
+// LANCACHE_SERVER or CLIENT_TAGS, does not count as removing them.
+func (s *SyncService) checkRemovalLimits(currentDomains, newDomains []string) error {
+	proposed := uniqueDomains(newDomains)
+	existing, removed := 0, 0
+	for domain := range uniqueDomains(currentDomains) {
+		existing++
+		if _, ok := proposed[domain]; !ok {
+			removed++
+		}
+	}
+	if existing == 0 {
+		return nil
+	}
+
+	percent := float64(removed) * 100 / float64(existing)
+	limits := s.config.RemovalLimits
+	exceeded := (limits.MaxPercent > 0 && percent > limits.MaxPercent) ||
+		(limits.MaxCount > 0 && removed > limits.MaxCount)
+	if !exceeded {
+		return nil
+	}
+
+	if limits.Override {
+		slog.Warn("Removing many managed rules, allowed by override",
+			"removed", removed, "existing", existing, "percent", fmt.Sprintf("%.1f", percent))
+		return nil
+	}
+
+	return fmt.Errorf("%w: would remove %d of %d rules (%.1f%%, limits: %.1f%% or %d rules), use -allow-mass-removal to apply anyway",
+		ErrMassRemoval, removed, existing, percent, limits.MaxPercent, limits.MaxCount)
+}
+
+func uniqueDomains(domains []string) map[string]struct{} {
+	unique := make(map[string]struct{}, len(domains))
+	for _, domain := range domains {
+		unique[domain] = struct{}{}
+	}
+	return unique
+}
+
+// ruleDomains returns the domains of managed rules. Rules whose domain cannot
+// be determined, e.g. from custom templates, are compared as a whole.
+func ruleDomains(rules []string) []string {
+	domains := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		if isComment(rule) {
+			continue
+		}
+		if domain, ok := ruleDomain(rule); ok {
+			domains = append(domains, domain)
+		} else {
+			domains = append(domains, rule)
+		}
+	}
+	return domains
+}
+
+// syncedDomains returns the domains of the last successful sync, see recordSync.
+func syncedDomains(st *state.State) []string {
+	var domains []string
+	for _, service := range st.Services {
+		for _, files := range service.Files {
+			domains = append(domains, files...)
+		}
+	}
+	return domains
+}
+
+func rewriteDomains(rewrites []types.DNSRewrite) []string {
+	domains := make([]string, len(rewrites))
+	for i, rewrite := range rewrites {
+		domains[i] = rewrite.Domain
+	}
+	return domains
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/skaronator/lancache-dns-sync/internal/config"
+	"github.com/skaronator/lancache-dns-sync/internal/types"
+)
+
+func TestSyncService_RemovalLimits(t *testing.T) {
+	// The outdated header is not counted as a removed rule
+	existingRules := []string{"||custom.com^", startMarker, "# lancache-dns-sync v1.0.0, updated 2024-06-01T00:00:00Z, upstream commit abc"}
+	for i := range 10 {
+		existingRules = append(existingRules, fmt.Sprintf("|domain%d.com^$dnsrewrite=192.168.1.1", i))
+	}
+	existingRules = append(existingRules, endMarker)
+
+	rewrites := func(count int) []types.DNSRewrite {
+		var rewrites []types.DNSRewrite
+		for i := range count {
+			rewrites = append(rewrites, types.DNSRewrite{Domain: fmt.Sprintf("domain%d.com", i), Answer: "192.168.1.1"})
+		}
+		return rewrites
+	}
+
+	tests := []struct {
+		name        string
+		limits      config.RemovalLimits
+		keep        int
+		expectError bool
+	}{
+		{"within percent limit", config.RemovalLimits{MaxPercent: 50}, 5, false},
+		{"exceeds percent limit", config.RemovalLimits{MaxPercent: 50}, 4, true},
+		{"empty upstream", config.RemovalLimits{MaxPercent: 50}, 0, true},
+		{"within count limit", config.RemovalLimits{MaxCount: 3}, 7, false},
+		{"exceeds count limit", config.RemovalLimits{MaxCount: 3}, 6, true},
+		{"override", config.RemovalLimits{MaxPercent: 10, Override: true}, 0, false},
+		{"limits disabled", config.RemovalLimits{}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mockAdguardClient{
+				filteringStatus: &types.FilterStatus{UserRules: existingRules},
+			}
+			service := NewSyncService(client, nil, &config.Config{RemovalLimits: tt.limits})
+
+			err := service.UpdateFilteringRules(context.Background(), rewrites(tt.keep))
+
+			if tt.expectError {
+				if !errors.Is(err, ErrMassRemoval) {
+					t.Errorf("Expected ErrMassRemoval, got %v", err)
+				}
+				if client.setRulesCalled {
+					t.Error("Expected SetFilteringRules not to be called")
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Expected no error but got: %v", err)
+			}
+			if !client.setRulesCalled {
+				t.Error("Expected SetFilteringRules to be called")
+			}
+		})
+	}
+}
+
+func TestSyncService_RemovalLimitsRenderingChanged(t *testing.T) {
+	existingRules := []string{startMarker}
+	var rewrites []types.DNSRewrite
+	for i := range 10 {
+		existingRules = append(existingRules, fmt.Sprintf("|domain%d.com^$dnsrewrite=192.168.1.1", i))
+		rewrites = append(rewrites, types.DNSRewrite{Domain: fmt.Sprintf("domain%d.com", i), Answer: "192.168.1.2", Service: "steam"})
+	}
+	existingRules = append(existingRules, endMarker)
+
+	client := &mockAdguardClient{
+		filteringStatus: &types.FilterStatus{UserRules: existingRules},
+	}
+	// A new cache IP and client tags change every rule but remove no domain
+	cfg := &config.Config{
+		RemovalLimits: config.RemovalLimits{MaxPercent: 50},
+		ClientTags:    map[string][]string{"*": {"device_pc"}},
+	}
+	service := NewSyncService(client, nil, cfg)
+
+	if err := service.UpdateFilteringRules(context.Background(), rewrites); err != nil {
+		t.Errorf("Expected no error but got: %v", err)
+	}
+	if !client.setRulesCalled {
+		t.Error("Expected SetFilteringRules to be called")
+	}
+}
+
+func TestSyncService_RemovalLimitsFirstSync(t *testing.T) {
+	client := &mockAdguardClient{
+		filteringStatus: &types.FilterStatus{UserRules: []string{"||custom.com^"}},
+	}
+	cfg := &config.Config{RemovalLimits: config.RemovalLimits{MaxPercent: 1, MaxCount: 1}}
+	service := NewSyncService(client, nil, cfg)
+
+	err := service.UpdateFilteringRules(context.Background(), []types.DNSRewrite{{Domain: "test.com", Answer: "192.168.1.1"}})
+	if err != nil {
+		t.Errorf("Expected no error without existing managed rules, got %v", err)
+	}
+}
+
+func TestSyncService_RemovalLimitsFilterList(t *testing.T) {
+	client := &mockAdguardClient{filteringStatus: &types.FilterStatus{}}
+	cfg := &config.Config{
+		SyncMode:      config.SyncModeFilterList,
+		FilterList:    config.FilterListConfig{URL: "http://sync:8080/filter.txt"},
+		RemovalLimits: config.RemovalLimits{MaxPercent: 50},
+	}
+	cfg.StateDir = t.TempDir()
+	service := NewSyncService(client, nil, cfg)
+
+	rewrites := []types.DNSRewrite{
+		{Domain: "a.com", Answer: "192.168.1.1", Service: "steam", Source: "steam.txt"},
+		{Domain: "b.com", Answer: "192.168.1.1", Service: "steam", Source: "steam.txt"},
+	}
+	if err := service.PublishFilterList(context.Background(), rewrites); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	service.recordSync(rewrites)
+
+	if err := service.PublishFilterList(context.Background(), nil); !errors.Is(err, ErrMassRemoval) {
+		t.Errorf("Expected ErrMassRemoval, got %v", err)
+	}
+	if len(service.FilterList().Rules()) != 2 {
+		t.Errorf("Expected previous rules to be kept, got %v", service.FilterList().Rules())
+	}
+
+	// After a restart the served list is empty, the limits still apply
+	restarted := NewSyncService(client, nil, cfg)
+	restarted.loadState()
+	if err := restarted.PublishFilterList(context.Background(), rewrites[:0]); !errors.Is(err, ErrMassRemoval) {
+		t.Errorf("Expected ErrMassRemoval after a restart, got %v", err)
+	}
+}
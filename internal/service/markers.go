@@ -0,0 +1,145 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// ErrCorruptedMarkers is returned when the markers of the managed section are
+// unbalanced, nested or duplicated, so the managed rules cannot be told apart
+// from the user's own rules.
+var ErrCorruptedMarkers = errors.New("corrupted managed section markers")
+
+// markerProblems describes what is wrong with the markers in rules. Line
+// numbers are 1-based, as shown in the AdGuard custom filtering rules.
+func markerProblems(rules []string, m markers) []string {
+	var problems []string
+	openedAt := 0
+	firstSection := 0
+
+	for i, rule := range rules {
+		line := i + 1
+		switch rule {
+		case m.start:
+			if openedAt != 0 {
+				problems = append(problems, fmt.Sprintf("start marker at line %d is nested in the section started at line %d", line, openedAt))
+				continue
+			}
+			if firstSection != 0 {
+				problems = append(problems, fmt.Sprintf("duplicate section starting at line %d, the first one starts at line %d", line, firstSection))
+			} else {
+				firstSection = line
+			}
+			openedAt = line
+		case m.end:
+			if openedAt == 0 {
+				problems = append(problems, fmt.Sprintf("end marker at line %d has no start marker", line))
+				continue
+			}
+			openedAt = 0
+		}
+	}
+	if openedAt != 0 {
+		problems = append(problems, fmt.Sprintf("start marker at line %d has no end marker", openedAt))
+	}
+	return problems
+}
+
+// checkMarkers returns rules if they contain at most one well-formed managed
+// section. Stray end markers after the section are dropped and corrupted
+// markers are repaired when the managed rules of the last write are found next
+// to one of them; otherwise ErrCorruptedMarkers is returned and nothing must be
+// written.
+func (s *SyncService) checkMarkers(rules []string) ([]string, error) {
+	if cleaned := dropStrayEndMarkers(rules, s.markers); len(markerProblems(cleaned, s.markers)) == 0 {
+		if len(cleaned) != len(rules) {
+			slog.Warn("Dropping stray end markers after the managed section", "count", len(rules)-len(cleaned))
+		}
+		return cleaned, nil
+	}
+
+	problems := markerProblems(rules, s.markers)
+
+	if repaired, ok := s.repairMarkers(rules); ok {
+		slog.Warn("Repaired corrupted managed section markers using the last written section",
+			"problems", strings.Join(problems, "; "))
+		return repaired, nil
+	}
+
+	return nil, fmt.Errorf("%w %q/%q in the user rules: %s; fix the markers in the AdGuard custom filtering rules so exactly one section surrounds the managed rules, or restore a backup with the restore command",
+		ErrCorruptedMarkers, s.markers.start, s.markers.end, strings.Join(problems, "; "))
+}
+
+// dropStrayEndMarkers removes end markers without a start marker that follow
+// a closed section. As the rules before them are outside of the section either
+// way, dropping them cannot remove a user rule.
+func dropStrayEndMarkers(rules []string, m markers) []string {
+	cleaned := make([]string, 0, len(rules))
+	open, closed := false, false
+	for _, rule := range rules {
+		switch rule {
+		case m.start:
+			open = true
+		case m.end:
+			if !open && closed {
+				continue
+			}
+			open, closed = false, true
+		}
+		cleaned = append(cleaned, rule)
+	}
+	return cleaned
+}
+
+// withoutMarkers returns rules without the markers of m, keeping every rule
+// between them.
+func withoutMarkers(rules []string, m markers) []string {
+	return slices.DeleteFunc(slices.Clone(rules), m.isMarker)
+}
+
+// repairMarkers locates the managed rules of the last write by their
+// fingerprint and rebuilds a single section around them. Other markers of
+// this instance are dropped, keeping the rules between them as user rules,
+// so no rule that cannot be attributed to this instance is ever removed.
+func (s *SyncService) repairMarkers(rules []string) ([]string, bool) {
+	fingerprint := s.state.ManagedBlock
+	if fingerprint == nil || fingerprint.Count == 0 {
+		// An empty section leaves nothing to locate it by
+		return nil, false
+	}
+	n := fingerprint.Count
+
+	found := -1
+	for k := 0; k+n <= len(rules); k++ {
+		nextToMarker := (k > 0 && rules[k-1] == s.markers.start) || (k+n < len(rules) && rules[k+n] == s.markers.end)
+		if !nextToMarker || !fingerprint.Matches(rules[k:k+n]) {
+			continue
+		}
+		if found != -1 {
+			// Ambiguous, the block appears more than once
+			return nil, false
+		}
+		found = k
+	}
+	if found == -1 {
+		return nil, false
+	}
+
+	repaired := []string{}
+	for i, rule := range rules {
+		if i == found {
+			repaired = append(repaired, s.markers.start)
+		}
+		inBlock := i >= found && i < found+n
+		if inBlock || !s.markers.isMarker(rule) {
+			repaired = append(repaired, rule)
+		}
+		if i == found+n-1 {
+			repaired = append(repaired, s.markers.end)
+		}
+	}
+	return repaired, true
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/skaronator/lancache-dns-sync/internal/config"
+	"github.com/skaronator/lancache-dns-sync/internal/state"
+	"github.com/skaronator/lancache-dns-sync/internal/types"
+)
+
+func TestMarkerProblems(t *testing.T) {
+	m := newMarkers("")
+	tests := []struct {
+		name     string
+		rules    []string
+		expected []string
+	}{
+		{
+			name:  "no section",
+			rules: []string{"||custom.com^"},
+		},
+		{
+			name:  "single section",
+			rules: []string{"||custom.com^", startMarker, "|a.com^$dnsrewrite=192.168.1.1", endMarker},
+		},
+		{
+			name:     "missing end marker",
+			rules:    []string{"||custom.com^", startMarker, "|a.com^$dnsrewrite=192.168.1.1"},
+			expected: []string{"start marker at line 2 has no end marker"},
+		},
+		{
+			name:     "missing start marker",
+			rules:    []string{"|a.com^$dnsrewrite=192.168.1.1", endMarker, "||custom.com^"},
+			expected: []string{"end marker at line 2 has no start marker"},
+		},
+		{
+			name:  "nested start marker",
+			rules: []string{startMarker, "|a.com^$dnsrewrite=192.168.1.1", startMarker, endMarker, endMarker},
+			expected: []string{
+				"start marker at line 3 is nested in the section started at line 1",
+				"end marker at line 5 has no start marker",
+			},
+		},
+		{
+			name:     "duplicate section",
+			rules:    []string{startMarker, endMarker, "||custom.com^", startMarker, endMarker},
+			expected: []string{"duplicate section starting at line 4, the first one starts at line 1"},
+		},
+		{
+			name:  "other instance markers are ignored",
+			rules: []string{"# lancache-dns-sync:lanparty start", "|a.com^$dnsrewrite=10.0.0.1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := markerProblems(tt.rules, m)
+			if !slices.Equal(problems, tt.expected) {
+				t.Errorf("Expected problems %q, got %q", tt.expected, problems)
+			}
+		})
+	}
+}
+
+func TestSyncService_CheckMarkers(t *testing.T) {
+	managed := []string{"|a.com^$dnsrewrite=192.168.1.1", "|b.com^$dnsrewrite=192.168.1.1"}
+
+	tests := []struct {
+		name        string
+		rules       []string
+		lastWritten []string
+		expected    []string
+		expectError bool
+	}{
+		{
+			name:        "missing end marker without fingerprint",
+			rules:       []string{startMarker, managed[0], managed[1], "||custom.com^"},
+			expectError: true,
+		},
+		{
+			name:        "missing end marker repaired",
+			rules:       []string{startMarker, managed[0], managed[1], "||custom.com^"},
+			lastWritten: managed,
+			expected:    []string{startMarker, managed[0], managed[1], endMarker, "||custom.com^"},
+		},
+		{
+			name:        "missing start marker repaired",
+			rules:       []string{"||custom.com^", managed[0], managed[1], endMarker},
+			lastWritten: managed,
+			expected:    []string{"||custom.com^", startMarker, managed[0], managed[1], endMarker},
+		},
+		{
+			name:        "stray markers dropped",
+			rules:       []string{endMarker, "||custom.com^", startMarker, managed[0], managed[1], endMarker, endMarker},
+			lastWritten: managed,
+			expected:    []string{"||custom.com^", startMarker, managed[0], managed[1], endMarker},
+		},
+		{
+			name:     "stray end marker after the section",
+			rules:    []string{"||custom.com^", startMarker, managed[0], endMarker, "||after.com^", endMarker},
+			expected: []string{"||custom.com^", startMarker, managed[0], endMarker, "||after.com^"},
+		},
+		{
+			name:        "managed rules edited",
+			rules:       []string{startMarker, managed[0], "||custom.com^"},
+			lastWritten: managed,
+			expectError: true,
+		},
+		{
+			name:        "ambiguous repair",
+			rules:       []string{startMarker, managed[0], managed[1], startMarker, managed[0], managed[1]},
+			lastWritten: managed,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewSyncService(&mockAdguardClient{}, nil, &config.Config{})
+			if tt.lastWritten != nil {
+				service.state.ManagedBlock = state.NewFingerprint(tt.lastWritten)
+			}
+
+			rules, err := service.checkMarkers(tt.rules)
+			if tt.expectError {
+				if !errors.Is(err, ErrCorruptedMarkers) {
+					t.Errorf("Expected ErrCorruptedMarkers, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error but got: %v", err)
+			}
+			if !slices.Equal(rules, tt.expected) {
+				t.Errorf("Expected rules %q, got %q", tt.expected, rules)
+			}
+		})
+	}
+}
+
+func TestSyncService_UpdateFilteringRulesCorruptedMarkers(t *testing.T) {
+	rewrites := []types.DNSRewrite{{Domain: "test.com", Answer: "192.168.1.1"}}
+	corrupted := []string{
+		startMarker,
+		"|old.com^$dnsrewrite=192.168.1.1",
+		"||custom.com^",
+	}
+
+	client := &mockAdguardClient{
+		filteringStatus: &types.FilterStatus{UserRules: corrupted},
+	}
+	service := NewSyncService(client, nil, &config.Config{})
+
+	err := service.UpdateFilteringRules(context.Background(), rewrites)
+	if !errors.Is(err, ErrCorruptedMarkers) {
+		t.Fatalf("Expected ErrCorruptedMarkers, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("Expected error to name the line, got %v", err)
+	}
+	if client.setRulesCalled {
+		t.Error("Expected SetFilteringRules not to be called")
+	}
+
+	// Knowing the last written section, the sync can repair the markers
+	service.state.ManagedBlock = state.NewFingerprint([]string{"|old.com^$dnsrewrite=192.168.1.1"})
+	if err := service.UpdateFilteringRules(context.Background(), rewrites); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	expected := []string{"||custom.com^", startMarker, testHeader, "|test.com^$dnsrewrite=192.168.1.1", endMarker}
+	if !slices.Equal(client.lastRules, expected) {
+		t.Errorf("Expected rules %q, got %q", expected, client.lastRules)
+	}
+	if !service.state.ManagedBlock.Matches(expected[2:4]) {
+		t.Error("Expected fingerprint of the written section to be recorded")
+	}
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/skaronator/lancache-dns-sync/internal/config"
+	"github.com/skaronator/lancache-dns-sync/internal/state"
+)
+
+// Pause removes the managed rules for d, e.g. during maintenance of the cache.
+// Syncs keep them removed until the pause expires or Resume is called. It
+// returns the end of the pause.
+func (s *SyncService) Pause(ctx context.Context, d time.Duration) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The pause is saved first, so a sync running elsewhere does not add the
+	// rules back in the meantime
+	pause := &state.Pause{Until: now().Add(d).UTC()}
+	if err := s.savePause(pause); err != nil {
+		return time.Time{}, err
+	}
+	slog.Info("Paused, removing the managed rules", "until", pause.Until.Format(time.RFC3339))
+
+	if s.config.SyncMode == config.SyncModeFilterList {
+		s.filterList.SetRules(nil)
+	}
+	if _, err := s.Cleanup(ctx, CleanupOptions{}); err != nil {
+		return time.Time{}, err
+	}
+	return pause.Until, nil
+}
+
+// Resume ends the pause and reports whether there was one. The managed rules
+// are restored by the next sync.
+func (s *SyncService) Resume() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.currentPause() == nil {
+		return false, nil
+	}
+	if err := s.savePause(nil); err != nil {
+		return false, err
+	}
+	slog.Info("Resumed, the next sync restores the managed rules")
+	return true, nil
+}
+
+// currentPause returns the pause, which may have expired already. With a state
+// directory it is read on every call to see pauses set by the pause command.
+func (s *SyncService) currentPause() *state.Pause {
+	if s.pauseStore != nil {
+		p, err := s.pauseStore.Load()
+		if err != nil {
+			slog.Warn("Failed to load pause, keeping the last known one", "error", err)
+			return s.pause
+		}
+		s.pause = p
+	}
+	return s.pause
+}
+
+// savePause stores the pause, nil ends it. Without a state directory the
+// pause only lives as long as the process.
+func (s *SyncService) savePause(p *state.Pause) error {
+	if s.pauseStore != nil {
+		var err error
+		if p == nil {
+			err = s.pauseStore.Clear()
+		} else {
+			err = s.pauseStore.Save(p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	s.pause = p
+	return nil
+}
+
+// checkPause keeps the managed rules removed while paused, and ends an
+// expired pause. paused is true if the sync must not continue.
+func (s *SyncService) checkPause(ctx context.Context) (paused bool, err error) {
+	p := s.currentPause()
+	if p == nil {
+		return false, nil
+	}
+
+	if !now().Before(p.Until) {
+		slog.Info("Pause expired, restoring the managed rules", "paused_until", p.Until.Format(time.RFC3339))
+		if err := s.savePause(nil); err != nil {
+			return false, fmt.Errorf("failed to end pause: %w", err)
+		}
+		return false, nil
+	}
+
+	s.lastResult.PausedUntil = &p.Until
+	slog.Info("Paused, keeping the managed rules removed", "until", p.Until.Format(time.RFC3339))
+	if err := s.removeManagedRules(ctx); err != nil {
+		return true, fmt.Errorf("failed to remove managed rules while paused: %w", err)
+	}
+	return true, nil
+}
+
+// PauseChanged reports whether the pause was set or ended since the last sync,
+// e.g. by the pause command of another process.
+func (s *SyncService) PauseChanged() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var until, seen time.Time
+	if p := s.currentPause(); p != nil {
+		until = p.Until
+	}
+	if s.lastResult.PausedUntil != nil {
+		seen = *s.lastResult.PausedUntil
+	}
+	return !until.Equal(seen)
+}
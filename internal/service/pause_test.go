@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/skaronator/lancache-dns-sync/internal/config"
+	"github.com/skaronator/lancache-dns-sync/internal/types"
+)
+
+func TestSyncService_Pause(t *testing.T) {
+	client := &mockAdguardClient{
+		filteringStatus: &types.FilterStatus{UserRules: []string{
+			"||custom.com^",
+			startMarker,
+			"|managed.com^$dnsrewrite=192.168.1.1",
+			endMarker,
+		}},
+	}
+	cfg := &config.Config{SyncMode: config.SyncModeUserRules, StateDir: t.TempDir()}
+	service := NewSyncService(client, nil, cfg)
+
+	until, err := service.Pause(context.Background(), 2*time.Hour)
+	if err != nil {
+		t.Fatalf("Pause() error = %v", err)
+	}
+	if expected := time.Date(2025, 1, 1, 2, 0, 0, 0, time.UTC); !until.Equal(expected) {
+		t.Errorf("Pause() = %v, want %v", until, expected)
+	}
+	if !slices.Equal(client.lastRules, []string{"||custom.com^"}) {
+		t.Errorf("Expected the managed rules to be removed, got %v", client.lastRules)
+	}
+
+	// A daemon sharing the state directory keeps the rules removed, without
+	// downloading anything
+	client.filteringStatus = &types.FilterStatus{UserRules: client.lastRules}
+	client.setRulesCalled = false
+	daemon := NewSyncService(client, nil, cfg)
+	result, err := daemon.SyncDomains(context.Background())
+	if err != nil {
+		t.Fatalf("SyncDomains() error = %v", err)
+	}
+	if result.PausedUntil == nil || !result.PausedUntil.Equal(until) {
+		t.Errorf("Expected PausedUntil %v, got %v", until, result.PausedUntil)
+	}
+	if client.setRulesCalled {
+		t.Error("Expected no write while paused")
+	}
+
+	// The pause ends once it expired
+	defer func(previous func() time.Time) { now = previous }(now)
+	now = func() time.Time { return until }
+	paused, err := daemon.checkPause(context.Background())
+	if err != nil || paused {
+		t.Errorf("checkPause() = %v, %v, want the pause to be over", paused, err)
+	}
+	if p := service.currentPause(); p != nil {
+		t.Errorf("Expected the expired pause to be cleared, got %v", p)
+	}
+}
+
+func TestSyncService_Resume(t *testing.T) {
+	client := &mockAdguardClient{filteringStatus: &types.FilterStatus{}}
+	service := NewSyncService(client, nil, &config.Config{SyncMode: config.SyncModeUserRules})
+
+	if resumed, err := service.Resume(); err != nil || resumed {
+		t.Errorf("Resume() = %v, %v, want nothing to resume", resumed, err)
+	}
+
+	if _, err := service.Pause(context.Background(), time.Hour); err != nil {
+		t.Fatalf("Pause() error = %v", err)
+	}
+	if resumed, err := service.Resume(); err != nil || !resumed {
+		t.Errorf("Resume() = %v, %v, want the pause to end", resumed, err)
+	}
+	if p := service.currentPause(); p != nil {
+		t.Errorf("Expected no pause after Resume(), got %v", p)
+	}
+}
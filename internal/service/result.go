@@ -0,0 +1,141 @@
+package service
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/skaronator/lancache-dns-sync/internal/state"
+	"github.com/skaronator/lancache-dns-sync/internal/types"
+)
+
+// SyncResult summarizes what the last sync did.
+type SyncResult struct {
+	StartedAt time.Time `json:"started_at"`
+	// AdguardWritten is true when the rules in AdGuard were changed.
+	AdguardWritten bool `json:"adguard_written"`
+	// Unchanged is true when the managed rules already matched and the
+	// write was skipped.
+	Unchanged bool `json:"unchanged"`
+	DryRun    bool `json:"dry_run"`
+	// OutsideWindow is true when the sync ran outside of the event windows
+	// and removed the managed rules instead of applying them.
+	OutsideWindow bool `json:"outside_window,omitempty"`
+	// PausedUntil is set when the sync ran during a pause and removed the
+	// managed rules instead of applying them.
+	PausedUntil *time.Time `json:"paused_until,omitempty"`
+	// UpstreamCommit is the cache-domains commit the domains were taken
+	// from, if it could be determined.
+	UpstreamCommit string `json:"upstream_commit,omitempty"`
+	// Services lists the synced services sorted by name.
+	Services []ServiceResult `json:"services"`
+	// DomainsAdded and DomainsRemoved are the domains that changed since
+	// the last successful sync.
+	DomainsAdded   []string `json:"domains_added"`
+	DomainsRemoved []string `json:"domains_removed"`
+	// FilesFailed are the domain files that could not be downloaded.
+	FilesFailed     []string `json:"files_failed"`
+	BytesDownloaded int64    `json:"bytes_downloaded"`
+	Timings         Timings  `json:"timings"`
+	// Targets describes where the rules were applied.
+	Targets []TargetResult `json:"targets"`
+	// Anomalies found in the upstream data. The affected services kept
+	// the rules of the last successful sync.
+	Anomalies []Anomaly `json:"anomalies"`
+}
+
+// ServiceResult holds the domain counts of one service.
+type ServiceResult struct {
+	Name    string `json:"name"`
+	Domains int    `json:"domains"`
+	Added   int    `json:"added"`
+	Removed int    `json:"removed"`
+}
+
+// TargetResult describes the outcome of applying the rules to one target,
+// the AdGuard user rules or the served filter list.
+type TargetResult struct {
+	Target  string `json:"target"`
+	Rules   int    `json:"rules"`
+	Written bool   `json:"written"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Timings holds the duration of each sync phase.
+type Timings struct {
+	// Fetch is the time spent fetching the cache domains index.
+	Fetch    time.Duration
+	Download time.Duration
+	// Apply is the time spent writing the rules to the target.
+	Apply time.Duration
+	Total time.Duration
+}
+
+// MarshalJSON encodes the durations as strings like "1.5s".
+func (t Timings) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{
+		"fetch":    t.Fetch.String(),
+		"download": t.Download.String(),
+		"apply":    t.Apply.String(),
+		"total":    t.Total.String(),
+	})
+}
+
+// serviceResults counts the domains of rewrites per service and compares
+// them with the domains of the previous sync.
+func serviceResults(previous *state.State, rewrites []types.DNSRewrite) (services []ServiceResult, added, removed []string) {
+	current := make(map[string][]string)
+	for _, rewrite := range rewrites {
+		current[rewrite.Service] = append(current[rewrite.Service], rewrite.Domain)
+	}
+
+	names := make(map[string]bool)
+	for name := range current {
+		names[name] = true
+	}
+	for name := range previous.Services {
+		names[name] = true
+	}
+
+	added, removed = []string{}, []string{}
+	for _, name := range slices.Sorted(maps.Keys(names)) {
+		var before []string
+		for _, domains := range previous.Services[name].Files {
+			before = append(before, domains...)
+		}
+		serviceAdded := difference(current[name], before)
+		serviceRemoved := difference(before, current[name])
+		added = append(added, serviceAdded...)
+		removed = append(removed, serviceRemoved...)
+
+		if len(current[name]) == 0 && len(serviceRemoved) == 0 {
+			continue
+		}
+		services = append(services, ServiceResult{
+			Name:    name,
+			Domains: len(current[name]),
+			Added:   len(serviceAdded),
+			Removed: len(serviceRemoved),
+		})
+	}
+	slices.Sort(added)
+	slices.Sort(removed)
+	return services, added, removed
+}
+
+// difference returns the domains of a that are not in b.
+func difference(a, b []string) []string {
+	exclude := make(map[string]bool, len(b))
+	for _, domain := range b {
+		exclude[domain] = true
+	}
+	var diff []string
+	for _, domain := range a {
+		if !exclude[domain] {
+			diff = append(diff, domain)
+			exclude[domain] = true
+		}
+	}
+	return diff
+}
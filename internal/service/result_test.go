@@ -0,0 +1,65 @@
+package service
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/skaronator/lancache-dns-sync/internal/state"
+	"github.com/skaronator/lancache-dns-sync/internal/types"
+)
+
+func TestServiceResults(t *testing.T) {
+	previous := state.New()
+	previous.Services = map[string]state.ServiceState{
+		"steam": {Files: map[string][]string{"steam.txt": {"a.steam.com", "b.steam.com"}}},
+		"epic":  {Files: map[string][]string{"epic.txt": {"epic.com"}}},
+	}
+	rewrites := []types.DNSRewrite{
+		{Domain: "a.steam.com", Service: "steam"},
+		{Domain: "c.steam.com", Service: "steam"},
+		{Domain: "origin.com", Service: "origin"},
+	}
+
+	services, added, removed := serviceResults(previous, rewrites)
+
+	expectedServices := []ServiceResult{
+		{Name: "epic", Domains: 0, Added: 0, Removed: 1},
+		{Name: "origin", Domains: 1, Added: 1, Removed: 0},
+		{Name: "steam", Domains: 2, Added: 1, Removed: 1},
+	}
+	if !slices.Equal(services, expectedServices) {
+		t.Errorf("Expected services %+v, got %+v", expectedServices, services)
+	}
+	if expected := []string{"c.steam.com", "origin.com"}; !slices.Equal(added, expected) {
+		t.Errorf("Expected added %v, got %v", expected, added)
+	}
+	if expected := []string{"b.steam.com", "epic.com"}; !slices.Equal(removed, expected) {
+		t.Errorf("Expected removed %v, got %v", expected, removed)
+	}
+}
+
+func TestServiceResultsFirstSync(t *testing.T) {
+	rewrites := []types.DNSRewrite{{Domain: "a.steam.com", Service: "steam"}}
+
+	services, added, removed := serviceResults(state.New(), rewrites)
+
+	if expected := []ServiceResult{{Name: "steam", Domains: 1, Added: 1}}; !slices.Equal(services, expected) {
+		t.Errorf("Expected services %+v, got %+v", expected, services)
+	}
+	if !slices.Equal(added, []string{"a.steam.com"}) || len(removed) != 0 {
+		t.Errorf("Expected only added domains, got added %v, removed %v", added, removed)
+	}
+}
+
+func TestTimingsMarshalJSON(t *testing.T) {
+	data, err := json.Marshal(Timings{Fetch: 150 * time.Millisecond, Download: 2 * time.Second, Total: 2150 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	expected := `{"apply":"0s","download":"2s","fetch":"150ms","total":"2.15s"}`
+	if string(data) != expected {
+		t.Errorf("Expected %s, got %s", expected, data)
+	}
+}
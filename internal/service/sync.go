@@ -1,14 +1,26 @@
 package service
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/skaronator/lancache-dns-sync/internal/backup"
 	"github.com/skaronator/lancache-dns-sync/internal/client"
 	"github.com/skaronator/lancache-dns-sync/internal/config"
 	"github.com/skaronator/lancache-dns-sync/internal/domain"
+	"github.com/skaronator/lancache-dns-sync/internal/filterlist"
+	"github.com/skaronator/lancache-dns-sync/internal/ruletemplate"
+	"github.com/skaronator/lancache-dns-sync/internal/state"
 	"github.com/skaronator/lancache-dns-sync/internal/types"
 )
 
@@ -16,23 +28,153 @@ type SyncService struct {
 	client     client.AdguardClient
 	downloader *domain.Downloader
 	config     *config.Config
+	filterList *filterlist.List
+	backups    *backup.Store
+	// out receives the report of dry runs
+	out io.Writer
+
+	lastResult SyncResult
+	markers    markers
+	// version and upstreamCommit are named in the header of the managed section.
+	version        string
+	upstreamCommit string
+
+	state       *state.State
+	stateStore  *state.Store
+	stateLoaded bool
+
+	pause      *state.Pause
+	pauseStore *state.PauseStore
+
+	// mu serializes syncs and configuration reloads.
+	mu sync.Mutex
 }
 
+// now returns the current time, replaced in tests.
+var now = time.Now
+
+// ErrBackupsDisabled is returned by backup operations when no state directory is configured.
+var ErrBackupsDisabled = errors.New("backups are disabled, set STATE_DIR to enable them")
+
 func NewSyncService(client client.AdguardClient, downloader *domain.Downloader, cfg *config.Config) *SyncService {
-	return &SyncService{
+	s := &SyncService{
 		client:     client,
 		downloader: downloader,
 		config:     cfg,
+		filterList: filterlist.New(cfg.FilterList.Name),
+		out:        os.Stdout,
+		version:    "dev",
+		markers:    newMarkers(cfg.InstanceID),
+		state:      state.New(),
+	}
+	s.configureStores()
+	return s
+}
+
+// configureStores sets up the backup and state stores of the current configuration.
+func (s *SyncService) configureStores() {
+	s.backups = nil
+	s.stateStore = nil
+	s.pauseStore = nil
+	if s.config.StateDir == "" {
+		return
+	}
+	// Instances sharing a state directory must not mix their backups and state
+	suffix := ""
+	if s.config.InstanceID != "" {
+		suffix = "-" + s.config.InstanceID
+	}
+	s.backups = backup.NewStore(filepath.Join(s.config.StateDir, "backups"+suffix), s.config.BackupRetention, s.config.BackupMaxAge)
+	s.stateStore = state.NewStore(filepath.Join(s.config.StateDir, "state"+suffix+".json"))
+	s.pauseStore = state.NewPauseStore(filepath.Join(s.config.StateDir, "pause"+suffix+".json"))
+}
+
+// Reload replaces the configuration, waiting for a running sync to finish.
+// Settings that require a restart, like the AdGuard connection and the
+// instance, must be unchanged, see config.Config.RestartRequired.
+func (s *SyncService) Reload(cfg *config.Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stateDirChanged := cfg.StateDir != s.config.StateDir
+	s.config = cfg
+	s.configureStores()
+	if stateDirChanged {
+		// The state of the new directory is loaded on the next sync
+		s.state = state.New()
+		s.stateLoaded = false
+	}
+}
+
+// SetOutput sets where reports like the dry run diff are written, stdout by default.
+func (s *SyncService) SetOutput(w io.Writer) {
+	s.out = w
+}
+
+// SetVersion sets the version named in the header of the managed section.
+func (s *SyncService) SetVersion(version string) {
+	s.version = version
+}
+
+// FilterList returns the filter list served in filter list mode.
+func (s *SyncService) FilterList() *filterlist.List {
+	return s.filterList
+}
+
+// loadState reads the state of previous runs once. Without a state directory
+// the state only lives as long as the process.
+func (s *SyncService) loadState() {
+	if s.stateLoaded || s.stateStore == nil {
+		return
+	}
+	st, err := s.stateStore.Load()
+	if err != nil {
+		slog.Warn("Failed to load sync state, starting fresh", "error", err)
+		return
 	}
+	s.state = st
+	s.stateLoaded = true
+}
+
+// SyncDomains downloads the cache domains and applies them to the configured
+// target. The result is returned even when the sync fails, describing how far
+// it got.
+func (s *SyncService) SyncDomains(ctx context.Context) (SyncResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.syncDomains(ctx)
+	s.lastResult.Timings.Total = time.Since(s.lastResult.StartedAt)
+	return s.lastResult, err
 }
 
-func (s *SyncService) SyncDomains(ctx context.Context) error {
+func (s *SyncService) syncDomains(ctx context.Context) error {
+	s.lastResult = SyncResult{StartedAt: time.Now().UTC(), DryRun: s.config.DryRun}
+	s.loadState()
+
+	if paused, err := s.checkPause(ctx); paused || err != nil {
+		return err
+	}
+	if len(s.config.EventWindows) > 0 && !s.config.EventWindows.Active(now()) {
+		return s.removeOutsideWindow(ctx)
+	}
+
 	slog.Info("Fetching cache domains configuration")
+	phaseStart := time.Now()
 	domains, err := s.downloader.FetchCacheDomains(ctx)
+	s.lastResult.Timings.Fetch = time.Since(phaseStart)
 	if err != nil {
 		return fmt.Errorf("failed to fetch cache domains: %w", err)
 	}
 
+	commit, err := s.downloader.FetchUpstreamCommit(ctx)
+	if err != nil {
+		// Only used for the header of the managed section
+		slog.Debug("Failed to fetch upstream commit", "error", err)
+	}
+	s.upstreamCommit = commit
+	s.lastResult.UpstreamCommit = commit
+
 	filePaths := s.downloader.GetServiceFilePaths(domains, s.config)
 	if len(filePaths) == 0 {
 		slog.Info("No domain files to process")
@@ -40,26 +182,148 @@ func (s *SyncService) SyncDomains(ctx context.Context) error {
 	}
 
 	slog.Info("Downloading domains from files", "file_count", len(filePaths))
-	rewrites, err := s.downloader.DownloadDomainsFromFiles(ctx, filePaths, s.config.LancacheServer.String())
+	phaseStart = time.Now()
+	download, err := s.downloader.DownloadDomainFiles(ctx, filePaths, s.config.LancacheServer.String())
+	s.lastResult.Timings.Download = time.Since(phaseStart)
 	if err != nil {
 		return fmt.Errorf("failed to download domain files: %w", err)
 	}
+	rewrites := download.Rewrites
+	s.lastResult.FilesFailed = download.FailedFiles
+	s.lastResult.BytesDownloaded = download.Bytes
+
+	slog.Info("Downloaded domain entries", "count", len(rewrites), "bytes", download.Bytes, "failed_files", len(download.FailedFiles))
+
+	servicesByFile := s.downloader.ServicesByFile(domains)
+	for i := range rewrites {
+		rewrites[i].Service = servicesByFile[rewrites[i].Source]
+	}
 
-	slog.Info("Downloaded domain entries", "count", len(rewrites))
+	rewrites, s.lastResult.Anomalies = s.checkUpstream(domains, rewrites, download.FailedFiles, s.config.LancacheServer.String())
+	s.lastResult.Services, s.lastResult.DomainsAdded, s.lastResult.DomainsRemoved = serviceResults(s.state, rewrites)
 
-	if err := s.UpdateFilteringRules(ctx, rewrites); err != nil {
+	target := TargetResult{Target: s.config.SyncMode, Rules: len(rewrites)}
+	phaseStart = time.Now()
+	if s.config.SyncMode == config.SyncModeFilterList {
+		err = s.PublishFilterList(ctx, rewrites)
+	} else {
+		err = s.UpdateFilteringRules(ctx, rewrites)
+	}
+	s.lastResult.Timings.Apply = time.Since(phaseStart)
+	target.Written = s.lastResult.AdguardWritten
+	if err != nil {
+		target.Error = err.Error()
+	}
+	s.lastResult.Targets = append(s.lastResult.Targets, target)
+
+	if s.config.SyncMode == config.SyncModeFilterList {
+		if err != nil {
+			return fmt.Errorf("failed to publish filter list: %w", err)
+		}
+		s.recordSync(rewrites)
+		slog.Info("Filter list published successfully")
+		return nil
+	}
+
+	if err != nil {
 		return fmt.Errorf("failed to update filtering rules: %w", err)
 	}
 
+	if s.config.DryRun {
+		slog.Info("Dry run completed, filtering rules were not changed")
+		return nil
+	}
+	s.recordSync(rewrites)
+	if s.lastResult.Unchanged {
+		return nil
+	}
 	slog.Info("Filtering rules updated successfully")
 	return nil
 }
 
+// PublishFilterList serves the rewrites through the built-in filter list,
+// registers the list in AdGuard if necessary and makes AdGuard refresh it.
+// The user rules are not touched.
+func (s *SyncService) PublishFilterList(ctx context.Context, rewrites []types.DNSRewrite) error {
+	rules, err := s.buildRewriteRules(rewrites)
+	if err != nil {
+		return err
+	}
+	// The served list is empty after a restart, the state is not
+	if err := s.checkRemovalLimits(syncedDomains(s.state), rewriteDomains(rewrites)); err != nil {
+		return err
+	}
+	s.filterList.SetRules(rules)
+	slog.Debug("Filter list updated", "rules_count", len(rules))
+
+	status, err := s.client.GetFilteringStatus(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get filtering status: %w", err)
+	}
+
+	idx := slices.IndexFunc(status.Filters, func(f types.Filter) bool {
+		return f.URL == s.config.FilterList.URL
+	})
+	writeCtx, err := writeContext(ctx)
+	if err != nil {
+		return err
+	}
+	if idx < 0 {
+		// AdGuard downloads the list right away when it is added
+		slog.Info("Registering filter list in AdGuard", "url", s.config.FilterList.URL)
+		if err := s.client.AddFilterURL(writeCtx, s.config.FilterList.Name, s.config.FilterList.URL); err != nil {
+			return fmt.Errorf("failed to register filter list: %w", err)
+		}
+		s.lastResult.AdguardWritten = true
+		return nil
+	}
+
+	if !status.Filters[idx].Enabled {
+		slog.Warn("Filter list is disabled in AdGuard", "url", s.config.FilterList.URL)
+	}
+
+	if err := s.client.RefreshFilters(writeCtx); err != nil {
+		return fmt.Errorf("failed to refresh filter lists: %w", err)
+	}
+	s.lastResult.AdguardWritten = true
+	return nil
+}
+
 const (
 	startMarker = "# lancache-dns-sync start"
 	endMarker   = "# lancache-dns-sync end"
 )
 
+// markers delimit the managed section of one instance in the user rules.
+type markers struct {
+	start string
+	end   string
+}
+
+// newMarkers returns the markers of the instance. Without an instance ID the
+// plain markers are used, which keeps existing setups working.
+func newMarkers(instanceID string) markers {
+	if instanceID == "" {
+		return markers{start: startMarker, end: endMarker}
+	}
+	return markers{
+		start: fmt.Sprintf("# lancache-dns-sync:%s start", instanceID),
+		end:   fmt.Sprintf("# lancache-dns-sync:%s end", instanceID),
+	}
+}
+
+func (m markers) isMarker(rule string) bool {
+	return rule == m.start || rule == m.end
+}
+
+// maxUpdateAttempts limits how often the read-modify-write of the user rules
+// is repeated when they are edited concurrently, e.g. in the AdGuard UI.
+const maxUpdateAttempts = 3
+
+// ErrConcurrentEdit is returned when the user rules kept changing while an
+// update was prepared.
+var ErrConcurrentEdit = errors.New("user rules were modified concurrently")
+
 func (s *SyncService) UpdateFilteringRules(ctx context.Context, rewrites []types.DNSRewrite) error {
 	slog.Debug("updateFilteringRules called", "rewrite_count", len(rewrites))
 
@@ -72,68 +336,310 @@ func (s *SyncService) UpdateFilteringRules(ctx context.Context, rewrites []types
 
 	slog.Info("Processing filtering rules", "count", len(rewrites))
 
-	existingRules := status.UserRules
-	slog.Debug("Existing rules in AdGuard", "count", len(existingRules))
+	managedRules, err := s.buildManagedRules(rewrites)
+	if err != nil {
+		return err
+	}
 
-	preservedRules := extractNonManagedRules(existingRules)
-	slog.Debug("Preserved non-managed rules", "count", len(preservedRules))
+	for attempt := 1; attempt <= maxUpdateAttempts; attempt++ {
+		existingRules, err := s.checkMarkers(status.UserRules)
+		if err != nil {
+			slog.Error("Refusing to update filtering rules", "error", err)
+			return err
+		}
+		slog.Debug("Existing rules in AdGuard", "count", len(existingRules), "attempt", attempt)
 
-	newRules := []string{}
-	newRules = append(newRules, preservedRules...)
-	newRules = append(newRules, startMarker)
-	slog.Debug("Added start marker", "rules_so_far", len(newRules))
+		preservedRules := extractNonManagedRules(existingRules, s.markers)
+		slog.Debug("Preserved non-managed rules", "count", len(preservedRules))
+		currentManagedRules := removedRules(existingRules, preservedRules)
 
-	slog.Debug("Building rewrite rules", "total_rewrites", len(rewrites))
-	for _, rewrite := range rewrites {
-		var rule string
-		if strings.HasPrefix(rewrite.Domain, "*.") {
-			// For wildcard domains, use || to match domain and all subdomains
-			domain := strings.TrimPrefix(rewrite.Domain, "*.")
-			rule = fmt.Sprintf("||%s^$dnsrewrite=%s", domain, rewrite.Answer)
+		// The header names the time of the last change, so a section with
+		// the same rules is kept as it is, including its comments
+		sectionRules := managedRules
+		unchanged := len(currentManagedRules) > 0 && slices.Equal(withoutComments(currentManagedRules), withoutComments(managedRules))
+		if unchanged {
+			sectionRules = currentManagedRules
+		}
+
+		newRules := []string{}
+		newRules = append(newRules, preservedRules...)
+		newRules = append(newRules, sectionRules...)
+
+		// Log the actual rules being sent
+		slog.Debug("Rules to be sent to AdGuard", "total_count", len(newRules))
+		if len(newRules) <= 20 {
+			slog.Debug("All rules being sent", "rules", newRules)
 		} else {
-			// For exact domains, use | to match only that specific domain
-			rule = fmt.Sprintf("|%s^$dnsrewrite=%s", rewrite.Domain, rewrite.Answer)
+			slog.Debug("First 10 rules", "rules", newRules[:10])
+			slog.Debug("Last 10 rules", "rules", newRules[len(newRules)-10:])
+		}
+
+		if s.config.DryRun {
+			if err := s.checkRemovalLimits(ruleDomains(currentManagedRules), ruleDomains(managedRules)); err != nil {
+				slog.Warn("These changes would be blocked", "error", err)
+			}
+			slog.Info("Dry run, not writing filtering rules")
+			if err := writeDryRun(s.out, existingRules, newRules, rewrites, s.markers, s.state); err != nil {
+				return fmt.Errorf("failed to write dry run report: %w", err)
+			}
+			return nil
+		}
+
+		// Writing makes AdGuard recompile its filtering engine, so skip it
+		// when the managed section is already up to date. Repaired markers
+		// must be written.
+		if unchanged && slices.Equal(existingRules, status.UserRules) {
+			slog.Info("No changes to the managed rules, skipping update", "total_rules", len(rewrites))
+			s.lastResult.Unchanged = true
+			s.state.ManagedBlock = state.NewFingerprint(currentManagedRules[1 : len(currentManagedRules)-1])
+			return nil
+		}
+
+		if err := s.checkRemovalLimits(ruleDomains(currentManagedRules), ruleDomains(managedRules)); err != nil {
+			slog.Error("Blocked update of filtering rules", "error", err)
+			return err
+		}
+
+		// Re-read the rules right before writing so edits made in the meantime
+		// are not overwritten. On a conflict the latest rules become the new base.
+		latest, err := s.client.GetFilteringStatus(ctx)
+		if err != nil {
+			slog.Error("Failed to re-read filtering status", "error", err)
+			return fmt.Errorf("failed to get filtering status: %w", err)
+		}
+		latestRules, err := s.checkMarkers(latest.UserRules)
+		if err != nil {
+			slog.Error("Refusing to update filtering rules", "error", err)
+			return err
+		}
+		if !slices.Equal(extractNonManagedRules(latestRules, s.markers), preservedRules) {
+			slog.Warn("User rules were modified while preparing the update, retrying", "attempt", attempt)
+			status = latest
+			continue
+		}
+
+		if err := s.setFilteringRules(ctx, latest.UserRules, newRules); err != nil {
+			slog.Error("Failed to set filtering rules", "error", err, "rules_count", len(newRules))
+			return fmt.Errorf("failed to set filtering rules: %w", err)
+		}
+
+		s.lastResult.AdguardWritten = true
+		s.state.ManagedBlock = state.NewFingerprint(sectionRules[1 : len(sectionRules)-1])
+
+		if attempt > 1 {
+			slog.Info("Resolved concurrent modification of user rules", "attempts", attempt)
 		}
-		newRules = append(newRules, rule)
+		slog.Info("Successfully updated filtering rules", "total_rules", len(rewrites))
+		slog.Debug("updateFilteringRules completed", "total_rules_sent", len(newRules))
+		return nil
 	}
-	slog.Debug("All rewrite rules added", "rules_count_after_rewrites", len(newRules))
 
-	newRules = append(newRules, endMarker)
-	slog.Debug("Added end marker", "final_rules_count", len(newRules))
+	return fmt.Errorf("%w: still changing after %d attempts", ErrConcurrentEdit, maxUpdateAttempts)
+}
 
-	// Log the actual rules being sent
-	slog.Debug("Rules to be sent to AdGuard", "total_count", len(newRules))
-	if len(newRules) <= 20 {
-		slog.Debug("All rules being sent", "rules", newRules)
-	} else {
-		slog.Debug("First 10 rules", "rules", newRules[:10])
-		slog.Debug("Last 10 rules", "rules", newRules[len(newRules)-10:])
+// writeContext guards a write to AdGuard. A canceled sync skips the write,
+// while a started write is finished even if ctx is canceled in the meantime,
+// so AdGuard is never left with half of a change.
+func writeContext(ctx context.Context) (context.Context, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("skipped writing to AdGuard: %w", err)
+	}
+	return context.WithoutCancel(ctx), nil
+}
+
+// setFilteringRules backs up the previous user rules, if enabled, and replaces
+// them with rules. Nothing is written when the backup fails.
+func (s *SyncService) setFilteringRules(ctx context.Context, previous, rules []string) error {
+	ctx, err := writeContext(ctx)
+	if err != nil {
+		return err
+	}
+	if s.backups != nil {
+		b, err := s.backups.Save(previous)
+		if err != nil {
+			return fmt.Errorf("failed to back up user rules: %w", err)
+		}
+		slog.Info("Backed up user rules", "backup", b.Name, "rules_count", len(previous))
 	}
 
 	slog.Debug("Calling SetFilteringRules on AdGuard client")
-	if err := s.client.SetFilteringRules(ctx, newRules); err != nil {
-		slog.Error("Failed to set filtering rules", "error", err, "rules_count", len(newRules))
-		return fmt.Errorf("failed to set filtering rules: %w", err)
+	return s.client.SetFilteringRules(ctx, rules)
+}
+
+// ListBackups returns the names of the stored user rules backups, newest first.
+func (s *SyncService) ListBackups() ([]string, error) {
+	if s.backups == nil {
+		return nil, ErrBackupsDisabled
+	}
+	return s.backups.List()
+}
+
+// RestoreBackup replaces the user rules in AdGuard with the backup of the
+// given name. The current user rules are backed up first.
+func (s *SyncService) RestoreBackup(ctx context.Context, name string) error {
+	if s.backups == nil {
+		return ErrBackupsDisabled
+	}
+
+	b, err := s.backups.Load(name)
+	if err != nil {
+		return err
+	}
+
+	status, err := s.client.GetFilteringStatus(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get filtering status: %w", err)
 	}
 
-	slog.Info("Successfully updated filtering rules", "total_rules", len(rewrites))
-	slog.Debug("updateFilteringRules completed", "total_rules_sent", len(newRules))
+	if err := s.setFilteringRules(ctx, status.UserRules, b.Rules); err != nil {
+		return fmt.Errorf("failed to restore backup: %w", err)
+	}
+
+	slog.Info("Restored user rules from backup", "backup", b.Name, "rules_count", len(b.Rules))
 	return nil
 }
 
-func extractNonManagedRules(rules []string) []string {
+// buildManagedRules returns the managed section including its markers. A
+// header names the tool version, update time and upstream commit, and the rules
+// of each service are preceded by a comment naming the service.
+func (s *SyncService) buildManagedRules(rewrites []types.DNSRewrite) ([]string, error) {
+	managedRules := []string{s.markers.start, s.managedHeader()}
+	for _, group := range groupByService(rewrites) {
+		if group.service != "" {
+			managedRules = append(managedRules, serviceHeader(group))
+		}
+		rules, err := s.buildRewriteRules(group.rewrites)
+		if err != nil {
+			return nil, err
+		}
+		managedRules = append(managedRules, rules...)
+	}
+	managedRules = append(managedRules, s.markers.end)
+	return managedRules, nil
+}
+
+func (s *SyncService) managedHeader() string {
+	upstream := s.upstreamCommit
+	if upstream == "" {
+		upstream = "unknown"
+	}
+	return fmt.Sprintf("# lancache-dns-sync %s, updated %s, upstream commit %s",
+		s.version, now().UTC().Format(time.RFC3339), upstream)
+}
+
+// serviceGroup holds the consecutive rewrites of one service.
+type serviceGroup struct {
+	service  string
+	rewrites []types.DNSRewrite
+}
+
+// groupByService groups rewrites by service in the order the services first
+// appear, keeping the order of the rewrites within each service.
+func groupByService(rewrites []types.DNSRewrite) []serviceGroup {
+	var groups []serviceGroup
+	index := make(map[string]int)
+	for _, rewrite := range rewrites {
+		i, ok := index[rewrite.Service]
+		if !ok {
+			i = len(groups)
+			index[rewrite.Service] = i
+			groups = append(groups, serviceGroup{service: rewrite.Service})
+		}
+		groups[i].rewrites = append(groups[i].rewrites, rewrite)
+	}
+	return groups
+}
+
+// serviceHeader describes a service, e.g.
+// "# service: steam (12 domains, source: steam.txt)".
+func serviceHeader(group serviceGroup) string {
+	var sources []string
+	for _, rewrite := range group.rewrites {
+		if rewrite.Source != "" && !slices.Contains(sources, rewrite.Source) {
+			sources = append(sources, rewrite.Source)
+		}
+	}
+	noun := "domains"
+	if len(group.rewrites) == 1 {
+		noun = "domain"
+	}
+	header := fmt.Sprintf("# service: %s (%d %s", group.service, len(group.rewrites), noun)
+	if len(sources) > 0 {
+		header += ", source: " + strings.Join(sources, ", ")
+	}
+	return header + ")"
+}
+
+// isComment reports whether rule is a comment, like the markers and the
+// annotations of the managed section.
+func isComment(rule string) bool {
+	return strings.HasPrefix(rule, "#")
+}
+
+// withoutComments returns the rules that are not comments.
+func withoutComments(rules []string) []string {
+	return slices.DeleteFunc(slices.Clone(rules), isComment)
+}
+
+func (s *SyncService) buildRewriteRules(rewrites []types.DNSRewrite) ([]string, error) {
+	slog.Debug("Building rewrite rules", "total_rewrites", len(rewrites))
+	rules := make([]string, 0, len(rewrites))
+	for _, rewrite := range rewrites {
+		rule, err := s.buildRule(rewrite)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	slog.Debug("All rewrite rules added", "rules_count", len(rules))
+	return rules, nil
+}
+
+var (
+	defaultExactTemplate    = ruletemplate.MustParse("exact", ruletemplate.DefaultExact)
+	defaultWildcardTemplate = ruletemplate.MustParse("wildcard", ruletemplate.DefaultWildcard)
+)
+
+func (s *SyncService) buildRule(rewrite types.DNSRewrite) (string, error) {
+	data := ruletemplate.Data{
+		Domain:     rewrite.Domain,
+		Answer:     rewrite.Answer,
+		Service:    rewrite.Service,
+		RecordType: ruletemplate.RecordType(rewrite.Answer),
+		// Restrict the rule to clients carrying one of the configured tags
+		ClientTags: strings.Join(s.config.ClientTagsFor(rewrite.Service), "|"),
+	}
+
+	// Exact rules match only the domain itself, wildcard rules its
+	// subdomains, too
+	tmpl := cmp.Or(s.config.RuleTemplates.Exact, defaultExactTemplate)
+	if strings.HasPrefix(rewrite.Domain, "*.") {
+		data.Domain = strings.TrimPrefix(rewrite.Domain, "*.")
+		tmpl = cmp.Or(s.config.RuleTemplates.Wildcard, defaultWildcardTemplate)
+	}
+
+	rule, err := tmpl.Execute(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to render rule for %s: %w", rewrite.Domain, err)
+	}
+	return rule, nil
+}
+
+// extractNonManagedRules returns the rules outside of the managed sections
+// delimited by m. Sections of other instances are preserved.
+func extractNonManagedRules(rules []string, m markers) []string {
 	slog.Debug("extractNonManagedRules called", "input_rules_count", len(rules))
 	preserved := []string{}
 	inManagedSection := false
 	managedRulesFound := 0
 
 	for i, rule := range rules {
-		if rule == startMarker {
+		if rule == m.start {
 			slog.Debug("Found start marker", "at_index", i)
 			inManagedSection = true
 			continue
 		}
-		if rule == endMarker {
+		if rule == m.end {
 			slog.Debug("Found end marker", "at_index", i, "managed_rules_found", managedRulesFound)
 			inManagedSection = false
 			continue
@@ -3,17 +3,27 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"os"
+	"slices"
 	"testing"
 	"time"
 
 	"github.com/skaronator/lancache-dns-sync/internal/config"
 	"github.com/skaronator/lancache-dns-sync/internal/domain"
+	"github.com/skaronator/lancache-dns-sync/internal/ruletemplate"
 	"github.com/skaronator/lancache-dns-sync/internal/types"
 )
 
+// testHeader is the header of the managed section written with the fixed clock of the tests.
+const testHeader = "# lancache-dns-sync dev, updated 2025-01-01T00:00:00Z, upstream commit unknown"
 
+func TestMain(m *testing.M) {
+	now = func() time.Time { return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC) }
+	os.Exit(m.Run())
+}
 
 func TestExtractNonManagedRules(t *testing.T) {
 	tests := []struct {
@@ -147,7 +157,7 @@ func TestExtractNonManagedRules(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := extractNonManagedRules(tt.input)
+			result := extractNonManagedRules(tt.input, newMarkers(""))
 
 			if len(result) != len(tt.expected) {
 				t.Errorf("Expected %d rules, got %d", len(tt.expected), len(result))
@@ -169,18 +179,52 @@ type mockAdguardClient struct {
 	setRulesError   error
 	setRulesCalled  bool
 	lastRules       []string
+	// onSetRules, if set, runs while the rules are written
+	onSetRules     func()
+	setRulesCtxErr error
+
+	addedFilterURL   string
+	removedFilterURL string
+	refreshedCalled  bool
+
+	// statusSequence, if set, is returned one entry per call, repeating the last one
+	statusSequence []*types.FilterStatus
+	statusCalls    int
 }
 
 func (m *mockAdguardClient) GetFilteringStatus(ctx context.Context) (*types.FilterStatus, error) {
+	m.statusCalls++
+	if len(m.statusSequence) > 0 {
+		return m.statusSequence[min(m.statusCalls, len(m.statusSequence))-1], m.filteringError
+	}
 	return m.filteringStatus, m.filteringError
 }
 
 func (m *mockAdguardClient) SetFilteringRules(ctx context.Context, rules []string) error {
 	m.setRulesCalled = true
 	m.lastRules = rules
+	if m.onSetRules != nil {
+		m.onSetRules()
+	}
+	m.setRulesCtxErr = ctx.Err()
 	return m.setRulesError
 }
 
+func (m *mockAdguardClient) AddFilterURL(ctx context.Context, name, url string) error {
+	m.addedFilterURL = url
+	return nil
+}
+
+func (m *mockAdguardClient) RemoveFilterURL(ctx context.Context, url string) error {
+	m.removedFilterURL = url
+	return nil
+}
+
+func (m *mockAdguardClient) RefreshFilters(ctx context.Context) error {
+	m.refreshedCalled = true
+	return nil
+}
+
 type mockDownloader struct {
 	domains       *types.CacheDomainsResponse
 	domainsPaths  []string
@@ -229,7 +273,7 @@ func TestSyncService_SyncDomains(t *testing.T) {
 				},
 			},
 			expectError:       false,
-			expectedRuleCount: 5, // existing rule + start marker + 2 rules + end marker
+			expectedRuleCount: 6, // existing rule + start marker + header + 2 rules + end marker
 		},
 		{
 			name: "sync with wildcard domains",
@@ -252,7 +296,7 @@ func TestSyncService_SyncDomains(t *testing.T) {
 				},
 			},
 			expectError:       false,
-			expectedRuleCount: 5, // start marker + 3 rules + end marker
+			expectedRuleCount: 6, // start marker + header + 3 rules + end marker
 		},
 		{
 			name: "fetch domains fails",
@@ -360,6 +404,8 @@ func TestSyncService_updateFilteringRules(t *testing.T) {
 		rewrites       []types.DNSRewrite
 		expectError    bool
 		expectedRules  []string
+		clientTags     map[string][]string
+		ruleTemplates  config.RuleTemplates
 		getStatusError error
 		setRulesError  error
 	}{
@@ -371,6 +417,7 @@ func TestSyncService_updateFilteringRules(t *testing.T) {
 			},
 			expectedRules: []string{
 				startMarker,
+				testHeader,
 				"|test.com^$dnsrewrite=192.168.1.1",
 				endMarker,
 			},
@@ -391,6 +438,7 @@ func TestSyncService_updateFilteringRules(t *testing.T) {
 				"||custom.com^",
 				"||another.com^",
 				startMarker,
+				testHeader,
 				"|new.com^$dnsrewrite=192.168.1.2",
 				endMarker,
 			},
@@ -405,12 +453,74 @@ func TestSyncService_updateFilteringRules(t *testing.T) {
 			},
 			expectedRules: []string{
 				startMarker,
+				testHeader,
 				"||cdn.blizzard.com^$dnsrewrite=192.168.0.252",
 				"|cdn.blizzard.com^$dnsrewrite=192.168.0.252",
 				"|dist.blizzard.com^$dnsrewrite=192.168.0.252",
 				endMarker,
 			},
 		},
+		{
+			name:          "restrict rules to client tags",
+			existingRules: []string{},
+			rewrites: []types.DNSRewrite{
+				{Domain: "*.steamcontent.com", Answer: "192.168.1.1", Service: "steam"},
+				{Domain: "windowsupdate.com", Answer: "192.168.1.1", Service: "wsus"},
+				{Domain: "origin.com", Answer: "192.168.1.1", Service: "origin"},
+			},
+			clientTags: map[string][]string{
+				"steam": {"device_pc", "device_gameconsole"},
+				"wsus":  {"device_pc"},
+			},
+			expectedRules: []string{
+				startMarker,
+				testHeader,
+				"# service: steam (1 domain)",
+				"||steamcontent.com^$dnsrewrite=192.168.1.1,ctag=device_pc|device_gameconsole",
+				"# service: wsus (1 domain)",
+				"|windowsupdate.com^$dnsrewrite=192.168.1.1,ctag=device_pc",
+				"# service: origin (1 domain)",
+				"|origin.com^$dnsrewrite=192.168.1.1",
+				endMarker,
+			},
+		},
+		{
+			name:          "custom rule templates",
+			existingRules: []string{},
+			rewrites: []types.DNSRewrite{
+				{Domain: "*.steamcontent.com", Answer: "192.168.1.1", Service: "steam"},
+				{Domain: "steampowered.com", Answer: "fd00::1", Service: "steam"},
+			},
+			clientTags: map[string][]string{"steam": {"device_pc"}},
+			ruleTemplates: config.RuleTemplates{
+				Exact:    ruletemplate.MustParse("exact", "|{{.Domain}}^$dnsrewrite=NOERROR;{{.RecordType}};{{.Answer}},important"),
+				Wildcard: ruletemplate.MustParse("wildcard", "||{{.Domain}}^$dnsrewrite={{.Answer}},important"),
+			},
+			expectedRules: []string{
+				startMarker,
+				testHeader,
+				"# service: steam (2 domains)",
+				"||steamcontent.com^$dnsrewrite=192.168.1.1,important,ctag=device_pc",
+				"|steampowered.com^$dnsrewrite=NOERROR;AAAA;fd00::1,important,ctag=device_pc",
+				endMarker,
+			},
+		},
+		{
+			name:          "client tags with a template without modifiers",
+			existingRules: []string{},
+			rewrites:      []types.DNSRewrite{{Domain: "*.steamcontent.com", Answer: "192.168.1.1", Service: "steam"}},
+			clientTags:    map[string][]string{"steam": {"device_pc"}},
+			ruleTemplates: config.RuleTemplates{
+				Wildcard: ruletemplate.MustParse("wildcard", "||{{.Domain}}^"),
+			},
+			expectedRules: []string{
+				startMarker,
+				testHeader,
+				"# service: steam (1 domain)",
+				"||steamcontent.com^$ctag=device_pc",
+				endMarker,
+			},
+		},
 		{
 			name:           "get status fails",
 			getStatusError: errors.New("status error"),
@@ -435,7 +545,7 @@ func TestSyncService_updateFilteringRules(t *testing.T) {
 				setRulesError:  tt.setRulesError,
 			}
 
-			cfg := &config.Config{}
+			cfg := &config.Config{ClientTags: tt.clientTags, RuleTemplates: tt.ruleTemplates}
 			service := NewSyncService(client, nil, cfg)
 
 			err := service.UpdateFilteringRules(context.Background(), tt.rewrites)
@@ -480,3 +590,404 @@ func TestNewSyncService(t *testing.T) {
 		t.Error("Service config should match provided config")
 	}
 }
+
+func TestSyncService_UpdateFilteringRulesConcurrentEdit(t *testing.T) {
+	before := &types.FilterStatus{UserRules: []string{"||custom.com^"}}
+	edited := &types.FilterStatus{UserRules: []string{"||custom.com^", "||added-in-ui.com^"}}
+	rewrites := []types.DNSRewrite{{Domain: "test.com", Answer: "192.168.1.1"}}
+
+	t.Run("edit is merged", func(t *testing.T) {
+		client := &mockAdguardClient{
+			statusSequence: []*types.FilterStatus{before, edited},
+		}
+		service := NewSyncService(client, nil, &config.Config{})
+
+		if err := service.UpdateFilteringRules(context.Background(), rewrites); err != nil {
+			t.Fatalf("Expected no error but got: %v", err)
+		}
+
+		expected := []string{
+			"||custom.com^",
+			"||added-in-ui.com^",
+			startMarker,
+			testHeader,
+			"|test.com^$dnsrewrite=192.168.1.1",
+			endMarker,
+		}
+		if !slices.Equal(client.lastRules, expected) {
+			t.Errorf("Expected rules %v, got %v", expected, client.lastRules)
+		}
+	})
+
+	t.Run("rules keep changing", func(t *testing.T) {
+		var statuses []*types.FilterStatus
+		for i := range maxUpdateAttempts + 1 {
+			statuses = append(statuses, &types.FilterStatus{UserRules: []string{fmt.Sprintf("||custom%d.com^", i)}})
+		}
+		client := &mockAdguardClient{statusSequence: statuses}
+		service := NewSyncService(client, nil, &config.Config{})
+
+		err := service.UpdateFilteringRules(context.Background(), rewrites)
+		if !errors.Is(err, ErrConcurrentEdit) {
+			t.Errorf("Expected ErrConcurrentEdit, got %v", err)
+		}
+		if client.setRulesCalled {
+			t.Error("Expected SetFilteringRules not to be called")
+		}
+	})
+}
+
+func TestSyncService_PublishFilterList(t *testing.T) {
+	const listURL = "http://lancache-dns-sync:8080/filter.txt"
+	rewrites := []types.DNSRewrite{
+		{Domain: "*.steamcontent.com", Answer: "192.168.1.1"},
+		{Domain: "test.com", Answer: "192.168.1.1"},
+	}
+
+	tests := []struct {
+		name            string
+		filters         []types.Filter
+		expectAdded     bool
+		expectRefreshed bool
+	}{
+		{
+			name:        "register new filter list",
+			filters:     []types.Filter{{URL: "https://example.com/list.txt", Enabled: true}},
+			expectAdded: true,
+		},
+		{
+			name:            "refresh registered filter list",
+			filters:         []types.Filter{{URL: listURL, Enabled: true}},
+			expectRefreshed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mockAdguardClient{
+				filteringStatus: &types.FilterStatus{
+					UserRules: []string{"||custom.com^"},
+					Filters:   tt.filters,
+				},
+			}
+			cfg := &config.Config{
+				SyncMode:   config.SyncModeFilterList,
+				FilterList: config.FilterListConfig{URL: listURL, Name: "lancache"},
+			}
+			service := NewSyncService(client, nil, cfg)
+
+			if err := service.PublishFilterList(context.Background(), rewrites); err != nil {
+				t.Fatalf("Expected no error but got: %v", err)
+			}
+
+			if client.setRulesCalled {
+				t.Error("Expected user rules to stay untouched")
+			}
+			if (client.addedFilterURL == listURL) != tt.expectAdded {
+				t.Errorf("Expected filter list added = %v, got URL %q", tt.expectAdded, client.addedFilterURL)
+			}
+			if client.refreshedCalled != tt.expectRefreshed {
+				t.Errorf("Expected refresh = %v, got %v", tt.expectRefreshed, client.refreshedCalled)
+			}
+
+			expectedRules := []string{
+				"||steamcontent.com^$dnsrewrite=192.168.1.1",
+				"|test.com^$dnsrewrite=192.168.1.1",
+			}
+			if !slices.Equal(service.FilterList().Rules(), expectedRules) {
+				t.Errorf("Expected served rules %v, got %v", expectedRules, service.FilterList().Rules())
+			}
+		})
+	}
+}
+
+func TestSyncService_Backups(t *testing.T) {
+	client := &mockAdguardClient{
+		filteringStatus: &types.FilterStatus{UserRules: []string{"||custom.com^"}},
+	}
+	cfg := &config.Config{StateDir: t.TempDir(), BackupRetention: 10}
+	service := NewSyncService(client, nil, cfg)
+
+	rewrites := []types.DNSRewrite{{Domain: "test.com", Answer: "192.168.1.1"}}
+	if err := service.UpdateFilteringRules(context.Background(), rewrites); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+
+	names, err := service.ListBackups()
+	if err != nil {
+		t.Fatalf("ListBackups() error = %v", err)
+	}
+	if len(names) != 1 {
+		t.Fatalf("Expected 1 backup, got %d", len(names))
+	}
+
+	// Simulate the user rules being broken by a later sync
+	client.filteringStatus = &types.FilterStatus{UserRules: []string{"||broken.com^"}}
+	if err := service.RestoreBackup(context.Background(), names[0]); err != nil {
+		t.Fatalf("RestoreBackup() error = %v", err)
+	}
+	if !slices.Equal(client.lastRules, []string{"||custom.com^"}) {
+		t.Errorf("Expected restored rules, got %v", client.lastRules)
+	}
+
+	// The state before restoring is backed up as well
+	names, err = service.ListBackups()
+	if err != nil {
+		t.Fatalf("ListBackups() error = %v", err)
+	}
+	if len(names) != 2 {
+		t.Errorf("Expected 2 backups, got %d", len(names))
+	}
+}
+
+func TestSyncService_BackupsDisabled(t *testing.T) {
+	service := NewSyncService(&mockAdguardClient{}, nil, &config.Config{})
+
+	if _, err := service.ListBackups(); !errors.Is(err, ErrBackupsDisabled) {
+		t.Errorf("Expected ErrBackupsDisabled, got %v", err)
+	}
+	if err := service.RestoreBackup(context.Background(), "user-rules-20250101T120000.000Z"); !errors.Is(err, ErrBackupsDisabled) {
+		t.Errorf("Expected ErrBackupsDisabled, got %v", err)
+	}
+}
+
+func TestSyncService_UpdateFilteringRulesUnchanged(t *testing.T) {
+	rewrites := []types.DNSRewrite{{Domain: "test.com", Answer: "192.168.1.1"}}
+
+	tests := []struct {
+		name          string
+		existingRules []string
+		expectWrite   bool
+	}{
+		{
+			name: "managed section unchanged",
+			existingRules: []string{
+				"||custom.com^",
+				startMarker,
+				"|test.com^$dnsrewrite=192.168.1.1",
+				endMarker,
+			},
+			expectWrite: false,
+		},
+		{
+			name: "managed section unchanged with rules after it",
+			existingRules: []string{
+				startMarker,
+				"|test.com^$dnsrewrite=192.168.1.1",
+				endMarker,
+				"||custom.com^",
+			},
+			expectWrite: false,
+		},
+		{
+			name: "only the annotations differ",
+			existingRules: []string{
+				startMarker,
+				"# lancache-dns-sync v1.0.0, updated 2024-06-01T00:00:00Z, upstream commit abc",
+				"# service: old (1 domain)",
+				"|test.com^$dnsrewrite=192.168.1.1",
+				endMarker,
+			},
+			expectWrite: false,
+		},
+		{
+			name: "managed section changed",
+			existingRules: []string{
+				startMarker,
+				"|old.com^$dnsrewrite=192.168.1.1",
+				endMarker,
+			},
+			expectWrite: true,
+		},
+		{
+			name: "stray marker is cleaned up",
+			existingRules: []string{
+				startMarker,
+				"|test.com^$dnsrewrite=192.168.1.1",
+				endMarker,
+				endMarker,
+			},
+			expectWrite: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mockAdguardClient{
+				filteringStatus: &types.FilterStatus{UserRules: tt.existingRules},
+			}
+			service := NewSyncService(client, nil, &config.Config{})
+
+			if err := service.UpdateFilteringRules(context.Background(), rewrites); err != nil {
+				t.Fatalf("Expected no error but got: %v", err)
+			}
+
+			if client.setRulesCalled != tt.expectWrite {
+				t.Errorf("Expected SetFilteringRules called = %v, got %v", tt.expectWrite, client.setRulesCalled)
+			}
+			result := service.lastResult
+			if result.AdguardWritten != tt.expectWrite || result.Unchanged == tt.expectWrite {
+				t.Errorf("Unexpected result %+v", result)
+			}
+		})
+	}
+}
+
+func TestSyncService_Instances(t *testing.T) {
+	existingRules := []string{
+		"||custom.com^",
+		startMarker,
+		"|house.com^$dnsrewrite=192.168.1.1",
+		endMarker,
+		"# lancache-dns-sync:lanparty start",
+		"|old-lanparty.com^$dnsrewrite=10.0.0.1",
+		"# lancache-dns-sync:lanparty end",
+	}
+
+	client := &mockAdguardClient{
+		filteringStatus: &types.FilterStatus{UserRules: existingRules},
+	}
+	service := NewSyncService(client, nil, &config.Config{InstanceID: "lanparty"})
+
+	rewrites := []types.DNSRewrite{{Domain: "lanparty.com", Answer: "10.0.0.1"}}
+	if err := service.UpdateFilteringRules(context.Background(), rewrites); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+
+	expected := []string{
+		"||custom.com^",
+		startMarker,
+		"|house.com^$dnsrewrite=192.168.1.1",
+		endMarker,
+		"# lancache-dns-sync:lanparty start",
+		"# lancache-dns-sync dev, updated 2025-01-01T00:00:00Z, upstream commit unknown",
+		"|lanparty.com^$dnsrewrite=10.0.0.1",
+		"# lancache-dns-sync:lanparty end",
+	}
+	if !slices.Equal(client.lastRules, expected) {
+		t.Errorf("Expected rules %v, got %v", expected, client.lastRules)
+	}
+
+	// The default instance keeps the section of the named instance
+	preserved := extractNonManagedRules(client.lastRules, newMarkers(""))
+	expectedPreserved := []string{
+		"||custom.com^",
+		"# lancache-dns-sync:lanparty start",
+		"# lancache-dns-sync dev, updated 2025-01-01T00:00:00Z, upstream commit unknown",
+		"|lanparty.com^$dnsrewrite=10.0.0.1",
+		"# lancache-dns-sync:lanparty end",
+	}
+	if !slices.Equal(preserved, expectedPreserved) {
+		t.Errorf("Expected preserved rules %v, got %v", expectedPreserved, preserved)
+	}
+}
+
+func TestSyncService_UpdateFilteringRulesCanceled(t *testing.T) {
+	rewrites := []types.DNSRewrite{{Domain: "test.com", Answer: "192.168.1.1"}}
+
+	t.Run("canceled before writing", func(t *testing.T) {
+		client := &mockAdguardClient{filteringStatus: &types.FilterStatus{UserRules: []string{"||custom.com^"}}}
+		service := NewSyncService(client, nil, &config.Config{})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := service.UpdateFilteringRules(ctx, rewrites)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+		if client.setRulesCalled {
+			t.Error("Expected the write to be skipped")
+		}
+	})
+
+	t.Run("canceled while writing", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		client := &mockAdguardClient{
+			filteringStatus: &types.FilterStatus{UserRules: []string{"||custom.com^"}},
+			onSetRules:      cancel,
+		}
+		service := NewSyncService(client, nil, &config.Config{})
+
+		if err := service.UpdateFilteringRules(ctx, rewrites); err != nil {
+			t.Fatalf("Expected the started write to finish, got %v", err)
+		}
+		if client.setRulesCtxErr != nil {
+			t.Errorf("Expected the write not to be canceled, got %v", client.setRulesCtxErr)
+		}
+		if !service.lastResult.AdguardWritten {
+			t.Error("Expected the write to be recorded")
+		}
+	})
+}
+
+func TestSyncService_Reload(t *testing.T) {
+	client := &mockAdguardClient{
+		filteringStatus: &types.FilterStatus{UserRules: []string{"||custom.com^"}},
+	}
+	service := NewSyncService(client, nil, &config.Config{InstanceID: "lanparty"})
+
+	service.Reload(&config.Config{InstanceID: "lanparty", StateDir: t.TempDir(), BackupRetention: 10})
+
+	rewrites := []types.DNSRewrite{{Domain: "lanparty.com", Answer: "10.0.0.1"}}
+	if err := service.UpdateFilteringRules(context.Background(), rewrites); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+
+	expected := []string{
+		"||custom.com^",
+		"# lancache-dns-sync:lanparty start",
+		testHeader,
+		"|lanparty.com^$dnsrewrite=10.0.0.1",
+		"# lancache-dns-sync:lanparty end",
+	}
+	if !slices.Equal(client.lastRules, expected) {
+		t.Errorf("Expected rules %v, got %v", expected, client.lastRules)
+	}
+
+	// Backups are enabled by the new state directory
+	names, err := service.ListBackups()
+	if err != nil {
+		t.Fatalf("ListBackups() error = %v", err)
+	}
+	if len(names) != 1 {
+		t.Errorf("Expected 1 backup, got %d", len(names))
+	}
+}
+
+func TestBuildManagedRules(t *testing.T) {
+	service := NewSyncService(&mockAdguardClient{}, nil, &config.Config{})
+	service.SetVersion("v1.2.3")
+	service.upstreamCommit = "abc123"
+
+	rewrites := []types.DNSRewrite{
+		{Domain: "steampowered.com", Answer: "192.168.1.1", Service: "steam", Source: "steam.txt"},
+		{Domain: "origin.com", Answer: "192.168.1.1", Service: "origin", Source: "origin.txt"},
+		{Domain: "*.steamcontent.com", Answer: "192.168.1.1", Service: "steam", Source: "steam.txt"},
+		{Domain: "steam.example.com", Answer: "192.168.1.1", Service: "steam", Source: "steam-extra.txt"},
+	}
+
+	expected := []string{
+		startMarker,
+		"# lancache-dns-sync v1.2.3, updated 2025-01-01T00:00:00Z, upstream commit abc123",
+		"# service: steam (3 domains, source: steam.txt, steam-extra.txt)",
+		"|steampowered.com^$dnsrewrite=192.168.1.1",
+		"||steamcontent.com^$dnsrewrite=192.168.1.1",
+		"|steam.example.com^$dnsrewrite=192.168.1.1",
+		"# service: origin (1 domain, source: origin.txt)",
+		"|origin.com^$dnsrewrite=192.168.1.1",
+		endMarker,
+	}
+	rules, err := service.buildManagedRules(rewrites)
+	if err != nil {
+		t.Fatalf("buildManagedRules() error = %v", err)
+	}
+	if !slices.Equal(rules, expected) {
+		t.Errorf("Expected rules %q, got %q", expected, rules)
+	}
+
+	// The annotations are part of the managed section
+	if preserved := extractNonManagedRules(append([]string{"||custom.com^"}, rules...), service.markers); !slices.Equal(preserved, []string{"||custom.com^"}) {
+		t.Errorf("Expected only the custom rule to be preserved, got %q", preserved)
+	}
+}
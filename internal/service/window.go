@@ -0,0 +1,23 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// removeOutsideWindow runs instead of a sync outside of the event windows.
+func (s *SyncService) removeOutsideWindow(ctx context.Context) error {
+	s.lastResult.OutsideWindow = true
+	if next := s.config.EventWindows.NextChange(now()); next.IsZero() {
+		slog.Info("All event windows are over, removing the managed rules")
+	} else {
+		slog.Info("Outside of the event windows, removing the managed rules", "next_window", next.Format(time.RFC3339))
+	}
+
+	if err := s.removeManagedRules(ctx); err != nil {
+		return fmt.Errorf("failed to remove managed rules outside of the event windows: %w", err)
+	}
+	return nil
+}
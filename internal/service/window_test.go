@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/skaronator/lancache-dns-sync/internal/config"
+	"github.com/skaronator/lancache-dns-sync/internal/scheduler"
+	"github.com/skaronator/lancache-dns-sync/internal/types"
+)
+
+func TestSyncService_SyncDomainsOutsideWindow(t *testing.T) {
+	// The tests run at 2025-01-01, see TestMain
+	windows, err := scheduler.ParseWindows("2025-06-13T18:00/2025-06-15T22:00", time.UTC)
+	if err != nil {
+		t.Fatalf("ParseWindows() error = %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		userRules     []string
+		dryRun        bool
+		expectWritten bool
+	}{
+		{
+			name: "managed rules are removed",
+			userRules: []string{
+				"||custom.com^",
+				startMarker,
+				"|managed.com^$dnsrewrite=192.168.1.1",
+				endMarker,
+			},
+			expectWritten: true,
+		},
+		{
+			name:      "nothing to remove",
+			userRules: []string{"||custom.com^"},
+		},
+		{
+			name: "dry run",
+			userRules: []string{
+				"||custom.com^",
+				startMarker,
+				"|managed.com^$dnsrewrite=192.168.1.1",
+				endMarker,
+			},
+			dryRun: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mockAdguardClient{
+				filteringStatus: &types.FilterStatus{UserRules: tt.userRules},
+			}
+			cfg := &config.Config{SyncMode: config.SyncModeUserRules, EventWindows: windows, DryRun: tt.dryRun}
+			// Nothing is downloaded outside of the windows
+			service := NewSyncService(client, nil, cfg)
+
+			result, err := service.SyncDomains(context.Background())
+			if err != nil {
+				t.Fatalf("SyncDomains() error = %v", err)
+			}
+			if !result.OutsideWindow {
+				t.Error("Expected OutsideWindow to be set")
+			}
+			if result.AdguardWritten != tt.expectWritten || client.setRulesCalled != tt.expectWritten {
+				t.Errorf("Expected written %v, got result %v and client %v", tt.expectWritten, result.AdguardWritten, client.setRulesCalled)
+			}
+			if tt.expectWritten && !slices.Equal(client.lastRules, []string{"||custom.com^"}) {
+				t.Errorf("Expected only the custom rules, got %v", client.lastRules)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Pause removes the managed rules until a point in time, e.g. while the cache
+// is under maintenance.
+type Pause struct {
+	Until time.Time `json:"until"`
+}
+
+// PauseStore persists the pause as JSON file. It is kept apart from the state,
+// which a running daemon only reads on startup, so the daemon sees pauses set
+// by the pause command.
+type PauseStore struct {
+	path string
+}
+
+func NewPauseStore(path string) *PauseStore {
+	return &PauseStore{path: path}
+}
+
+// Load reads the pause, returning nil if there is none.
+func (s *PauseStore) Load() (*Pause, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pause: %w", err)
+	}
+
+	var p Pause
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to decode pause %s: %w", s.path, err)
+	}
+	return &p, nil
+}
+
+// Save writes the pause atomically.
+func (s *PauseStore) Save(p *Pause) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("failed to marshal pause: %w", err)
+	}
+	if err := writeFile(s.path, data); err != nil {
+		return fmt.Errorf("failed to write pause: %w", err)
+	}
+	return nil
+}
+
+// Clear removes the pause.
+func (s *PauseStore) Clear() error {
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove pause: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package state
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPauseStore(t *testing.T) {
+	store := NewPauseStore(filepath.Join(t.TempDir(), "nested", "pause.json"))
+
+	p, err := store.Load()
+	if err != nil || p != nil {
+		t.Fatalf("Load() = %v, %v, want no pause", p, err)
+	}
+
+	until := time.Date(2025, 1, 1, 14, 0, 0, 0, time.UTC)
+	if err := store.Save(&Pause{Until: until}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	p, err = store.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if p == nil || !p.Until.Equal(until) {
+		t.Errorf("Load() = %v, want pause until %v", p, until)
+	}
+
+	if err := store.Clear(); err != nil {
+		t.Fatalf("Clear() error = %v", err)
+	}
+	if p, err := store.Load(); err != nil || p != nil {
+		t.Errorf("Load() after Clear() = %v, %v, want no pause", p, err)
+	}
+	// Clearing twice is fine
+	if err := store.Clear(); err != nil {
+		t.Errorf("Clear() error = %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// State is what the tool remembers between syncs.
+type State struct {
+	// Services holds the upstream data of the last successful sync.
+	Services map[string]ServiceState `json:"services"`
+	LastSync time.Time               `json:"last_sync"`
+	// ManagedBlock identifies the managed rules last written to AdGuard.
+	ManagedBlock *Fingerprint `json:"managed_block,omitempty"`
+}
+
+// Fingerprint identifies a list of rules without storing them.
+type Fingerprint struct {
+	Count  int    `json:"count"`
+	SHA256 string `json:"sha256"`
+}
+
+func NewFingerprint(rules []string) *Fingerprint {
+	hash := sha256.New()
+	for _, rule := range rules {
+		hash.Write([]byte(rule))
+		hash.Write([]byte{'\n'})
+	}
+	return &Fingerprint{Count: len(rules), SHA256: hex.EncodeToString(hash.Sum(nil))}
+}
+
+// Matches reports whether rules are the rules the fingerprint was taken of.
+func (f *Fingerprint) Matches(rules []string) bool {
+	return f != nil && len(rules) == f.Count && *NewFingerprint(rules) == *f
+}
+
+// ServiceState holds the domains of a service by domain file.
+type ServiceState struct {
+	Files map[string][]string `json:"files"`
+}
+
+// DomainCount returns the number of domains of the service.
+func (s ServiceState) DomainCount() int {
+	count := 0
+	for _, domains := range s.Files {
+		count += len(domains)
+	}
+	return count
+}
+
+func New() *State {
+	return &State{Services: make(map[string]ServiceState)}
+}
+
+// Store persists the state as JSON file.
+type Store struct {
+	path string
+}
+
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// Load reads the state, returning an empty state if none was saved yet.
+func (s *Store) Load() (*State, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return New(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state: %w", err)
+	}
+
+	st := New()
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("failed to decode state %s: %w", s.path, err)
+	}
+	if st.Services == nil {
+		st.Services = make(map[string]ServiceState)
+	}
+	return st, nil
+}
+
+// Save writes the state atomically.
+func (s *Store) Save(st *State) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+	if err := writeFile(s.path, data); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	return nil
+}
+
+// writeFile writes data atomically, creating the state directory if needed.
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,80 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreLoadMissing(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "state.json"))
+
+	st, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if st.Services == nil || len(st.Services) != 0 {
+		t.Errorf("Expected empty state, got %+v", st)
+	}
+}
+
+func TestStoreSaveAndLoad(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "nested", "state.json"))
+
+	st := New()
+	st.LastSync = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	st.Services["steam"] = ServiceState{Files: map[string][]string{
+		"steam.txt": {"*.steamcontent.com", "steampowered.com"},
+	}}
+
+	if err := store.Save(st); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	loaded, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !loaded.LastSync.Equal(st.LastSync) {
+		t.Errorf("Expected last sync %v, got %v", st.LastSync, loaded.LastSync)
+	}
+	if loaded.Services["steam"].DomainCount() != 2 {
+		t.Errorf("Expected 2 steam domains, got %d", loaded.Services["steam"].DomainCount())
+	}
+}
+
+func TestStoreLoadCorrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatalf("Failed to write state: %v", err)
+	}
+
+	if _, err := NewStore(path).Load(); err == nil {
+		t.Error("Expected error for corrupted state")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	rules := []string{"|a.com^$dnsrewrite=192.168.1.1", "|b.com^$dnsrewrite=192.168.1.1"}
+	fingerprint := NewFingerprint(rules)
+
+	if !fingerprint.Matches([]string{"|a.com^$dnsrewrite=192.168.1.1", "|b.com^$dnsrewrite=192.168.1.1"}) {
+		t.Error("Expected fingerprint to match identical rules")
+	}
+	if fingerprint.Matches([]string{"|a.com^$dnsrewrite=192.168.1.1"}) {
+		t.Error("Expected fingerprint not to match fewer rules")
+	}
+	if fingerprint.Matches([]string{"|a.com^$dnsrewrite=192.168.1.1", "||custom.com^"}) {
+		t.Error("Expected fingerprint not to match different rules")
+	}
+	// Joining must not make different splits collide
+	if NewFingerprint([]string{"a\nb"}).Matches([]string{"a", "b"}) {
+		t.Error("Expected fingerprint not to match differently split rules")
+	}
+
+	var empty *Fingerprint
+	if empty.Matches(nil) {
+		t.Error("Expected nil fingerprint to match nothing")
+	}
+}
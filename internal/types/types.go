@@ -10,15 +10,42 @@ type CacheDomainsResponse struct {
 }
 
 type DNSRewrite struct {
-	Domain string `json:"domain"`
-	Answer string `json:"answer"`
+	Domain  string `json:"domain"`
+	Answer  string `json:"answer"`
+	Service string `json:"service,omitempty"`
+	Source  string `json:"source,omitempty"`
+}
+
+type Filter struct {
+	ID          int64  `json:"id"`
+	Enabled     bool   `json:"enabled"`
+	URL         string `json:"url"`
+	Name        string `json:"name"`
+	RulesCount  int    `json:"rules_count"`
+	LastUpdated string `json:"last_updated,omitempty"`
 }
 
 type FilterStatus struct {
 	UserRules []string `json:"user_rules"`
+	Filters   []Filter `json:"filters"`
 	Enabled   bool     `json:"enabled"`
 }
 
 type SetRulesRequest struct {
 	Rules []string `json:"rules"`
 }
+
+type AddURLRequest struct {
+	Name      string `json:"name"`
+	URL       string `json:"url"`
+	Whitelist bool   `json:"whitelist"`
+}
+
+type RemoveURLRequest struct {
+	URL       string `json:"url"`
+	Whitelist bool   `json:"whitelist"`
+}
+
+type RefreshRequest struct {
+	Whitelist bool `json:"whitelist"`
+}